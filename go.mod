@@ -6,13 +6,17 @@ go 1.23.3
 replace github.com/go-git/go-git/v5 => github.com/meain/go-git/v5 v5.0.0-20250104052627-c7cb4f61a652
 
 require (
+	github.com/BurntSushi/toml v1.4.0
 	github.com/JohannesKaufmann/html-to-markdown v1.4.1
 	github.com/alecthomas/kong v1.6.0
 	github.com/asg017/sqlite-vec-go-bindings v0.1.6
 	github.com/go-git/go-billy/v5 v5.6.1
 	github.com/go-git/go-git/v5 v5.13.1
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
 	github.com/mattn/go-sqlite3 v1.14.24
 	golang.org/x/net v0.33.0
+	golang.org/x/text v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
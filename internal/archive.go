@@ -0,0 +1,442 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// archiveSeparator separates the archive path from the path of a file
+// within it (eg: bundle.zip!/docs/readme.md)
+const archiveSeparator = "!/"
+
+// maxTarCacheSize is the total size of the text files of a compressed
+// tar archive that are kept in memory while its documents are added
+const maxTarCacheSize = 64 << 20
+
+// archiveFile identifies a version of an archive on disk
+type archiveFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func statArchive(archive string) (archiveFile, error) {
+	info, err := os.Stat(archive)
+	if err != nil {
+		return archiveFile{}, fmt.Errorf("stat archive %s: %w", archive, err)
+	}
+
+	return archiveFile{path: archive, modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// zipContents is an opened zip archive with its files indexed by name
+type zipContents struct {
+	archiveFile
+	reader *zip.ReadCloser
+	files  map[string]*zip.File
+}
+
+// tarContents locates the files of a tar archive. Tar archives cannot be
+// read at random, so they are indexed in one pass. Files in uncompressed
+// archives are read from their offset, while the text files of
+// compressed archives are kept in memory up to maxTarCacheSize. Files
+// beyond that are found by reading the archive again.
+type tarContents struct {
+	archiveFile
+	compressed bool
+	entries    map[string]tarEntry
+	cached     map[string][]byte
+}
+
+// tarEntry is the location of a file in an uncompressed tar archive
+type tarEntry struct {
+	offset int64
+	size   int64
+}
+
+var (
+	// archiveCacheLock guards the archive caches and makes concurrent
+	// fetches from the same archive wait for a single read
+	archiveCacheLock sync.Mutex
+	// zipCache holds the last zip archive that was read
+	zipCache *zipContents
+	// tarCache holds the index of the last tar archive that was read
+	tarCache *tarContents
+)
+
+// isArchiveFile checks if the file is a supported archive based on its
+// extension
+func isArchiveFile(p string) bool {
+	return isZipFile(p) || isTarFile(p)
+}
+
+func isZipFile(p string) bool {
+	return strings.EqualFold(path.Ext(p), ".zip")
+}
+
+func isTarFile(p string) bool {
+	lower := strings.ToLower(p)
+	return strings.HasSuffix(lower, ".tar") || isGzipTarFile(p)
+}
+
+func isGzipTarFile(p string) bool {
+	lower := strings.ToLower(p)
+	return strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")
+}
+
+// splitArchivePath splits the path of a document within an archive into
+// the archive path and the path of the file within it
+func splitArchivePath(p string) (string, string, bool) {
+	offset := 0
+	for {
+		i := strings.Index(p[offset:], archiveSeparator)
+		if i == -1 {
+			return "", "", false
+		}
+
+		archive, name := p[:offset+i], p[offset+i+len(archiveSeparator):]
+		if name != "" && isArchiveFile(archive) && fileExists(archive) {
+			return archive, name, true
+		}
+
+		offset += i + len(archiveSeparator)
+	}
+}
+
+// expandArchive returns the paths of the text files in an archive,
+// skipping files larger than maxSize if it is set
+func expandArchive(archive string, maxSize int64) ([]string, error) {
+	var paths []string
+	err := walkArchive(archive, func(name string, size int64, r io.Reader) error {
+		if maxSize > 0 && size > maxSize {
+			return nil
+		}
+
+		head := make([]byte, 512)
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("read %s: %w", name, err)
+		}
+
+		if n > 0 && isTextContent(head[:n]) {
+			paths = append(paths, archive+archiveSeparator+name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
+
+// walkArchive calls fn for every regular file in the archive with its
+// uncompressed size
+func walkArchive(archive string, fn func(name string, size int64, r io.Reader) error) error {
+	if isZipFile(archive) {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return fmt.Errorf("open archive %s: %w", archive, err)
+		}
+		defer zr.Close()
+
+		for _, file := range zr.File {
+			if !file.Mode().IsRegular() {
+				continue
+			}
+
+			rc, err := file.Open()
+			if err != nil {
+				return fmt.Errorf("open %s in %s: %w", file.Name, archive, err)
+			}
+
+			err = fn(cleanArchiveName(file.Name), int64(file.UncompressedSize64), rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("open archive %s: %w", archive, err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if isGzipTarFile(archive) {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("decompress archive %s: %w", archive, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive %s: %w", archive, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := fn(cleanArchiveName(header.Name), header.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// cleanArchiveName normalizes the name of a file in an archive so that
+// the same file always gets the same path
+func cleanArchiveName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// fetchArchiveFile reads a text file from within an archive
+func fetchArchiveFile(p, archive, name string) (*Document, error) {
+	var (
+		content []byte
+		err     error
+	)
+	if isZipFile(archive) {
+		content, err = readZipFile(archive, name)
+	} else {
+		content, err = readTarFile(archive, name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	text, ok := decodeText(content)
+	if !ok {
+		return nil, fmt.Errorf("not a text file: %s", p)
+	}
+
+	doc := &Document{
+		Path:     p,
+		Content:  text,
+		Title:    p,
+		IsRemote: false,
+	}
+
+	if isMarkdownFile(name) {
+		parseMarkdownDocument(doc)
+	}
+
+	return doc, nil
+}
+
+// readZipFile reads a single file from a zip archive. The archive is
+// kept open with its files indexed while its documents are added.
+func readZipFile(archive, name string) ([]byte, error) {
+	current, err := statArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveCacheLock.Lock()
+	defer archiveCacheLock.Unlock()
+
+	if zipCache == nil || zipCache.archiveFile != current {
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, fmt.Errorf("open archive %s: %w", archive, err)
+		}
+
+		contents := &zipContents{archiveFile: current, reader: zr, files: map[string]*zip.File{}}
+		for _, file := range zr.File {
+			if file.Mode().IsRegular() {
+				contents.files[cleanArchiveName(file.Name)] = file
+			}
+		}
+
+		if zipCache != nil {
+			zipCache.reader.Close()
+		}
+		zipCache = contents
+	}
+
+	file, ok := zipCache.files[name]
+	if !ok {
+		return nil, fmt.Errorf("file %s in %s: %w", name, archive, fs.ErrNotExist)
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("open %s in %s: %w", name, archive, err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("read %s in %s: %w", name, archive, err)
+	}
+
+	return content, nil
+}
+
+// readTarFile reads a single file from a tar archive, indexing the
+// archive if it is not the one that was last read
+func readTarFile(archive, name string) ([]byte, error) {
+	current, err := statArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	archiveCacheLock.Lock()
+	defer archiveCacheLock.Unlock()
+
+	if tarCache == nil || tarCache.archiveFile != current {
+		contents, err := indexTarArchive(current)
+		if err != nil {
+			return nil, err
+		}
+		tarCache = contents
+	}
+
+	if content, ok := tarCache.cached[name]; ok {
+		return content, nil
+	}
+
+	if entry, ok := tarCache.entries[name]; ok {
+		f, err := os.Open(archive)
+		if err != nil {
+			return nil, fmt.Errorf("open archive %s: %w", archive, err)
+		}
+		defer f.Close()
+
+		content, err := io.ReadAll(io.NewSectionReader(f, entry.offset, entry.size))
+		if err != nil {
+			return nil, fmt.Errorf("read %s in %s: %w", name, archive, err)
+		}
+		return content, nil
+	}
+
+	if !tarCache.compressed {
+		return nil, fmt.Errorf("file %s in %s: %w", name, archive, fs.ErrNotExist)
+	}
+
+	// Files that did not fit in the cache are read in another pass
+	var content []byte
+	found := false
+	err = walkArchive(archive, func(file string, _ int64, r io.Reader) error {
+		if file != name || found {
+			return nil
+		}
+
+		found = true
+		read, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("read %s in %s: %w", name, archive, err)
+		}
+		content = read
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("file %s in %s: %w", name, archive, fs.ErrNotExist)
+	}
+
+	return content, nil
+}
+
+// indexTarArchive reads a tar archive in one pass, recording the offset
+// of every file in uncompressed archives and caching text files of
+// compressed ones
+func indexTarArchive(archive archiveFile) (*tarContents, error) {
+	contents := &tarContents{
+		archiveFile: archive,
+		compressed:  isGzipTarFile(archive.path),
+		entries:     map[string]tarEntry{},
+		cached:      map[string][]byte{},
+	}
+
+	f, err := os.Open(archive.path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive %s: %w", archive.path, err)
+	}
+	defer f.Close()
+
+	// The tar reader reads whole blocks without buffering ahead, so the
+	// bytes read after a header are the offset of the file data
+	counter := &countingReader{r: f}
+
+	var r io.Reader = counter
+	if contents.compressed {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, fmt.Errorf("decompress archive %s: %w", archive.path, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	cachedSize := int64(0)
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return contents, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read archive %s: %w", archive.path, err)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := cleanArchiveName(header.Name)
+		if !contents.compressed {
+			contents.entries[name] = tarEntry{offset: counter.n, size: header.Size}
+			continue
+		}
+
+		if cachedSize+header.Size > maxTarCacheSize {
+			continue
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("read %s in %s: %w", name, archive.path, err)
+		}
+
+		if isTextContent(content) {
+			contents.cached[name] = content
+			cachedSize += header.Size
+		}
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,332 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// Metadata keys set on documents imported from bookmarks
+const (
+	metadataTags          = "tags"
+	metadataBookmarkTitle = "bookmark_title"
+	metadataBookmarkAdded = "bookmark_added"
+)
+
+// bookmarkMetadataKeys are kept when a bookmarked page is fetched again
+// as they do not come from the page itself
+var bookmarkMetadataKeys = []string{metadataTags, metadataBookmarkTitle, metadataBookmarkAdded}
+
+// Bookmark is a URL imported from a bookmark export or a URL list
+type Bookmark struct {
+	URL   string
+	Title string
+	// Tags are the names of the folders the bookmark was in along with
+	// any tags set on it
+	Tags    []string
+	AddedAt time.Time
+}
+
+// metadata returns the document metadata for the bookmark
+func (b Bookmark) metadata() map[string]string {
+	metadata := map[string]string{}
+	if len(b.Tags) > 0 {
+		metadata[metadataTags] = strings.Join(b.Tags, ", ")
+	}
+	if b.Title != "" {
+		metadata[metadataBookmarkTitle] = b.Title
+	}
+	if !b.AddedAt.IsZero() {
+		metadata[metadataBookmarkAdded] = b.AddedAt.UTC().Format(time.RFC3339)
+	}
+	return metadata
+}
+
+// ParseBookmarks reads bookmarks from either a Netscape bookmark file
+// (the HTML format browsers export) or a plain list with one URL per
+// line. Lines in a URL list can have a title after the URL and lines
+// starting with # are ignored. Bookmarks for the same URL are merged.
+// Lines of a URL list without a valid URL are skipped and returned as
+// errors along with the bookmarks.
+func ParseBookmarks(r io.Reader) ([]Bookmark, []error, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read bookmarks: %w", err)
+	}
+
+	var bookmarks []Bookmark
+	var skipped []error
+	if isBookmarkHTML(data) {
+		bookmarks, err = parseBookmarkHTML(data)
+	} else {
+		bookmarks, skipped, err = parseURLList(data)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return mergeBookmarks(bookmarks), skipped, nil
+}
+
+// isBookmarkHTML checks if the data looks like a Netscape bookmark file
+// rather than a URL list
+func isBookmarkHTML(data []byte) bool {
+	head := bytes.ToLower(bytes.TrimSpace(data[:min(len(data), 1024)]))
+	return bytes.HasPrefix(head, []byte("<!doctype netscape-bookmark-file")) ||
+		bytes.HasPrefix(head, []byte("<")) && bytes.Contains(head, []byte("<dl"))
+}
+
+// parseBookmarkHTML parses the Netscape bookmark format. Folders are
+// <H3> headings each followed by a <DL> list holding their bookmarks.
+func parseBookmarkHTML(data []byte) ([]Bookmark, error) {
+	var (
+		bookmarks []Bookmark
+		folders   []string
+		// Folder heading seen but whose list has not started yet
+		pending     *string
+		namedFolder bool
+		current     *Bookmark
+		textBuf     strings.Builder
+		inHeading   bool
+	)
+
+	tokenizer := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		tt := tokenizer.Next()
+		switch tt {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return bookmarks, nil
+			}
+			return nil, fmt.Errorf("parse bookmarks: %w", tokenizer.Err())
+		case html.StartTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "h3":
+				inHeading = true
+				textBuf.Reset()
+				// The toolbar and unsorted folders are browser chrome
+				// rather than something the user named
+				namedFolder = tokenAttr(token, "personal_toolbar_folder") == "" &&
+					tokenAttr(token, "unfiled_bookmarks_folder") == ""
+			case "dl":
+				// Every list pushes an entry so that </dl> can pop it,
+				// lists without a named folder push an empty name
+				name := ""
+				if pending != nil {
+					name = *pending
+					pending = nil
+				}
+				folders = append(folders, name)
+			case "a":
+				href := strings.TrimSpace(tokenAttr(token, "href"))
+				if !IsRemoteURL(href) {
+					current = nil
+					continue
+				}
+
+				current = &Bookmark{URL: href}
+				for _, folder := range folders {
+					if folder != "" {
+						current.Tags = append(current.Tags, folder)
+					}
+				}
+				for _, tag := range strings.Split(tokenAttr(token, "tags"), ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						current.Tags = append(current.Tags, tag)
+					}
+				}
+				if seconds, err := strconv.ParseInt(tokenAttr(token, "add_date"), 10, 64); err == nil && seconds > 0 {
+					current.AddedAt = time.Unix(seconds, 0)
+				}
+				textBuf.Reset()
+			}
+		case html.TextToken:
+			if inHeading || current != nil {
+				textBuf.Write(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "h3":
+				inHeading = false
+				folder := ""
+				if namedFolder {
+					folder = strings.Join(strings.Fields(textBuf.String()), " ")
+				}
+				pending = &folder
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case "a":
+				if current != nil {
+					current.Title = strings.Join(strings.Fields(textBuf.String()), " ")
+					bookmarks = append(bookmarks, *current)
+					current = nil
+				}
+			}
+		}
+	}
+}
+
+// parseURLList parses a list of URLs with one URL per line optionally
+// followed by a title. Lines that do not start with a URL are skipped
+// and returned as errors.
+func parseURLList(data []byte) ([]Bookmark, []error, error) {
+	var bookmarks []Bookmark
+	var skipped []error
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		url, title, _ := strings.Cut(line, " ")
+		if !IsRemoteURL(url) {
+			skipped = append(skipped, fmt.Errorf("line %d: invalid URL %q", lineNumber, url))
+			continue
+		}
+
+		bookmarks = append(bookmarks, Bookmark{URL: url, Title: strings.TrimSpace(title)})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("read URL list: %w", err)
+	}
+
+	return bookmarks, skipped, nil
+}
+
+// mergeBookmarks combines bookmarks for the same URL keeping the first
+// title and the tags of all of them
+func mergeBookmarks(bookmarks []Bookmark) []Bookmark {
+	var merged []Bookmark
+	index := map[string]int{}
+
+	for _, b := range bookmarks {
+		i, ok := index[b.URL]
+		if !ok {
+			index[b.URL] = len(merged)
+			merged = append(merged, b)
+			continue
+		}
+
+		if merged[i].Title == "" {
+			merged[i].Title = b.Title
+		}
+		if merged[i].AddedAt.IsZero() {
+			merged[i].AddedAt = b.AddedAt
+		}
+		for _, tag := range b.Tags {
+			if !slices.Contains(merged[i].Tags, tag) {
+				merged[i].Tags = append(merged[i].Tags, tag)
+			}
+		}
+	}
+
+	return merged
+}
+
+// AddBookmarks fetches and adds the pages of the bookmarks in parallel.
+// The bookmark title is used as the document title and the tags are
+// stored as metadata.
+func AddBookmarks(ctx context.Context, db *sql.DB, bookmarks []Bookmark, maxWorkers int) []error {
+	if maxWorkers <= 0 {
+		maxWorkers = maxParallelEmbeddingRequests
+	}
+
+	bookmarkChan := make(chan Bookmark, len(bookmarks))
+	errChan := make(chan error, len(bookmarks))
+
+	var wg sync.WaitGroup
+	for i := 0; i < maxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range bookmarkChan {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := addBookmark(context.WithoutCancel(ctx), db, b); err != nil {
+					errChan <- fmt.Errorf("%s: %w", b.URL, err)
+				}
+			}
+		}()
+	}
+
+	for _, b := range bookmarks {
+		bookmarkChan <- b
+	}
+	close(bookmarkChan)
+
+	wg.Wait()
+	close(errChan)
+
+	var errors []error
+	for err := range errChan {
+		errors = append(errors, err)
+	}
+
+	return errors
+}
+
+// addBookmark fetches and stores the page of a single bookmark
+func addBookmark(ctx context.Context, db *sql.DB, b Bookmark) error {
+	previous := GetDocumentByPath(db, b.URL)
+
+	doc, err := FetchDocumentIfModified(b.URL, previous)
+	if errors.Is(err, ErrNotModified) {
+		// The page is current but the bookmark details might not be
+		doc = previous
+		doc.Metadata = maps.Clone(previous.Metadata)
+	} else if err != nil {
+		return fmt.Errorf("fetch document: %w", err)
+	}
+
+	applyBookmarkMetadata(doc, b.metadata())
+
+	return storeDocument(ctx, db, doc)
+}
+
+// applyBookmarkMetadata sets the bookmark metadata on a document and
+// uses the bookmark title as the document title
+func applyBookmarkMetadata(doc *Document, metadata map[string]string) {
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+
+	for _, key := range bookmarkMetadataKeys {
+		if value := metadata[key]; value != "" {
+			doc.Metadata[key] = value
+		}
+	}
+
+	if title := metadata[metadataBookmarkTitle]; title != "" {
+		doc.Title = title
+	}
+}
+
+func tokenAttr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
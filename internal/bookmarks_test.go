@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseURLList(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []Bookmark
+		skipped []string
+	}{
+		{
+			name: "urls with titles",
+			data: "https://example.com/a Page A\nhttp://example.com/b\n",
+			want: []Bookmark{
+				{URL: "https://example.com/a", Title: "Page A"},
+				{URL: "http://example.com/b"},
+			},
+		},
+		{
+			name: "comments and blank lines",
+			data: "# team links\n\n  https://example.com/a   Spaced title  \n",
+			want: []Bookmark{{URL: "https://example.com/a", Title: "Spaced title"}},
+		},
+		{
+			name: "invalid lines are skipped",
+			data: "https://example.com/a\nexample.com/b\nftp://example.com/c File\nhttps://example.com/d\n",
+			want: []Bookmark{
+				{URL: "https://example.com/a"},
+				{URL: "https://example.com/d"},
+			},
+			skipped: []string{
+				`line 2: invalid URL "example.com/b"`,
+				`line 3: invalid URL "ftp://example.com/c"`,
+			},
+		},
+		{
+			name: "empty",
+			data: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, skipped, err := parseURLList([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseURLList: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseURLList() = %+v, want %+v", got, tt.want)
+			}
+
+			var messages []string
+			for _, err := range skipped {
+				messages = append(messages, err.Error())
+			}
+			if !reflect.DeepEqual(messages, tt.skipped) {
+				t.Errorf("parseURLList() skipped %q, want %q", messages, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestParseBookmarkHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []Bookmark
+	}{
+		{
+			name: "folders are tags",
+			data: `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<DL><p>
+    <DT><H3 ADD_DATE="1700000000">Work</H3>
+    <DL><p>
+        <DT><H3>Go  Docs</H3>
+        <DL><p>
+            <DT><A HREF="https://go.dev/doc/" ADD_DATE="1700000100">Documentation</A>
+        </DL><p>
+        <DT><A HREF="https://example.com/wiki" TAGS="team, wiki">Team
+            wiki</A>
+    </DL><p>
+    <DT><A HREF="https://example.com/top">Top level</A>
+</DL><p>`,
+			want: []Bookmark{
+				{URL: "https://go.dev/doc/", Title: "Documentation", Tags: []string{"Work", "Go Docs"}, AddedAt: time.Unix(1700000100, 0)},
+				{URL: "https://example.com/wiki", Title: "Team wiki", Tags: []string{"Work", "team", "wiki"}},
+				{URL: "https://example.com/top", Title: "Top level"},
+			},
+		},
+		{
+			name: "browser folders are not tags",
+			data: `<DL><p>
+    <DT><H3 PERSONAL_TOOLBAR_FOLDER="true">Bookmarks bar</H3>
+    <DL><p>
+        <DT><A HREF="https://example.com/a">A</A>
+    </DL><p>
+    <DT><H3 UNFILED_BOOKMARKS_FOLDER="true">Other bookmarks</H3>
+    <DL><p>
+        <DT><A HREF="https://example.com/b">B</A>
+    </DL><p>
+</DL>`,
+			want: []Bookmark{
+				{URL: "https://example.com/a", Title: "A"},
+				{URL: "https://example.com/b", Title: "B"},
+			},
+		},
+		{
+			name: "links that are not pages are skipped",
+			data: `<DL><p>
+    <DT><A HREF="javascript:alert(1)">Bookmarklet</A>
+    <DT><A HREF="place:sort=8">Recent</A>
+    <DT><A HREF=" https://example.com/a ">A</A>
+</DL>`,
+			want: []Bookmark{{URL: "https://example.com/a", Title: "A"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBookmarkHTML([]byte(tt.data))
+			if err != nil {
+				t.Fatalf("parseBookmarkHTML: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBookmarkHTML() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeBookmarks(t *testing.T) {
+	got := mergeBookmarks([]Bookmark{
+		{URL: "https://example.com/a", Tags: []string{"work"}},
+		{URL: "https://example.com/b", Title: "B"},
+		{URL: "https://example.com/a", Title: "A", Tags: []string{"work", "reading"}},
+	})
+
+	want := []Bookmark{
+		{URL: "https://example.com/a", Title: "A", Tags: []string{"work", "reading"}},
+		{URL: "https://example.com/b", Title: "B"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeBookmarks() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// stdinPrefix is the prefix for documents read from stdin. These
+	// cannot be read again, so reindex keeps their content.
+	stdinPrefix = "stdin:"
+	// execPrefix is the prefix for documents created from the output
+	// of a command
+	execPrefix = "exec:"
+	// metadataCommand holds the command of a document added with
+	// --exec. Only documents with it are run again on reindex, the path
+	// alone does not show how a document was added.
+	metadataCommand = "command"
+	// execTimeout is the maximum time a command can run for
+	execTimeout = 5 * time.Minute
+)
+
+// terminalEscapes matches ANSI escape sequences and the backspace
+// overstrikes used by tools like man to render bold and underlined text
+var terminalEscapes = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]|\x1b\][^\x07]*\x07|.\x08`)
+
+// StdinPath returns the path of a document read from stdin
+func StdinPath(name string) string {
+	return stdinPrefix + name
+}
+
+// ExecPath returns the path of a document created from the output of a
+// command
+func ExecPath(command string) string {
+	return execPrefix + command
+}
+
+func isStdinPath(path string) bool {
+	return strings.HasPrefix(path, stdinPrefix)
+}
+
+func isExecPath(path string) bool {
+	return strings.HasPrefix(path, execPrefix)
+}
+
+// AddStdinDocument reads a document from r and adds it with the given
+// name
+func AddStdinDocument(ctx context.Context, db *sql.DB, name string, r io.Reader) error {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read stdin: %w", err)
+	}
+
+	content, ok := decodeText(raw)
+	if !ok {
+		return fmt.Errorf("stdin is not text")
+	}
+
+	doc := &Document{
+		Path:     StdinPath(name),
+		Content:  strings.TrimSpace(content),
+		Title:    name,
+		IsRemote: false,
+	}
+
+	if isMarkdownFile(name) {
+		parseMarkdownDocument(doc)
+		// There is no directory to resolve relative links against
+		doc.Links = nil
+	}
+
+	return storeDocument(ctx, db, doc)
+}
+
+// AddCommandDocument runs a command and adds its output as a document.
+// The command is run again on reindex to refresh the output.
+func AddCommandDocument(ctx context.Context, db *sql.DB, command string) error {
+	doc, err := fetchCommandOutput(command)
+	if err != nil {
+		return err
+	}
+	progressFrom(ctx).Fetched()
+
+	return storeDocument(ctx, db, doc)
+}
+
+// fetchCommandOutput runs a command using the shell and creates a
+// document from its output
+func fetchCommandOutput(command string) (*Document, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("run %q: %w: %s", command, err, msg)
+		}
+		return nil, fmt.Errorf("run %q: %w", command, err)
+	}
+
+	content, ok := decodeText(stdout.Bytes())
+	if !ok {
+		return nil, fmt.Errorf("output of %q is not text", command)
+	}
+
+	return &Document{
+		Path:     ExecPath(command),
+		Content:  strings.TrimSpace(terminalEscapes.ReplaceAllString(content, "")),
+		Title:    command,
+		Metadata: map[string]string{metadataCommand: command},
+		IsRemote: false,
+	}, nil
+}
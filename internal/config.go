@@ -2,8 +2,10 @@ package internal
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Config struct {
@@ -11,6 +13,10 @@ type Config struct {
 	EmbeddingModel   string `json:"embedding_model"`
 	APIKey           string `json:"api_key,omitempty"`
 	RerankerURL      string `json:"reranker_url,omitempty"`
+	// Minimum time between two fetches of a remote document (eg: "24h")
+	RemoteRefreshInterval string `json:"remote_refresh_interval,omitempty"`
+	// Settings used when fetching remote documents, keyed by host
+	Hosts map[string]HostConfig `json:"hosts,omitempty"`
 }
 
 func LoadConfig() (*Config, error) {
@@ -58,6 +64,17 @@ func LoadConfig() (*Config, error) {
 	Model = cfg.EmbeddingModel
 	APIKey = cfg.APIKey
 	RerankerURL = cfg.RerankerURL
+	if cfg.Hosts != nil {
+		HostConfigs = cfg.Hosts
+	}
+
+	if cfg.RemoteRefreshInterval != "" {
+		interval, err := time.ParseDuration(cfg.RemoteRefreshInterval)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid remote_refresh_interval: %w", err)
+		}
+		RemoteRefreshInterval = interval
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+	"golang.org/x/net/html/charset"
+)
+
+// ErrUnsupportedContentType is returned for remote documents with a
+// content type that cannot be converted to text
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// remoteMediaType returns the media type of a response, sniffing the
+// body if the server did not send one
+func remoteMediaType(resp *http.Response, body []byte) string {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	return strings.ToLower(mediaType)
+}
+
+// remoteCharset returns the charset from the Content-Type header of a
+// response if there is one
+func remoteCharset(resp *http.Response) string {
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return params["charset"]
+}
+
+// remoteText converts a response body to UTF-8 using the charset from
+// the response, detecting it if the server did not send one
+func remoteText(resp *http.Response, body []byte) (string, error) {
+	text, err := decodeTextCharset(body, remoteCharset(resp))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedContentType, err)
+	}
+
+	return text, nil
+}
+
+// remoteDocument converts a response body into a document using the
+// extractor for its content type
+func remoteDocument(url string, resp *http.Response, body []byte) (*Document, error) {
+	mediaType := remoteMediaType(resp, body)
+
+	var (
+		doc *Document
+		err error
+	)
+
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		doc, err = htmlDocument(url, htmlUTF8(resp, body))
+	case mediaType == "text/markdown" || mediaType == "text/x-markdown" ||
+		(mediaType == "text/plain" && isMarkdownFile(resp.Request.URL.Path)):
+		var text string
+		text, err = remoteText(resp, body)
+		if err != nil {
+			return nil, err
+		}
+		doc = textDocument(url, text)
+		doc.Metadata = map[string]string{}
+		parseMarkdownDocument(doc)
+		// Relative links cannot be resolved against a URL
+		doc.Links = nil
+		mediaType = "text/markdown"
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		doc, err = jsonDocument(url, body)
+	case mediaType == "application/pdf":
+		doc, err = pdfDocument(url, body)
+	case mediaType == "application/rss+xml" || mediaType == "application/atom+xml" ||
+		mediaType == "application/xml" || mediaType == "text/xml":
+		var feed *Feed
+		feed, err = parseFeed(body)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s (%v)", ErrUnsupportedContentType, mediaType, err)
+		}
+		doc = feedDocument(url, feed)
+	case strings.HasPrefix(mediaType, "text/"):
+		var text string
+		text, err = remoteText(resp, body)
+		if err == nil {
+			doc = textDocument(url, text)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedContentType, mediaType)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+	doc.Metadata[metadataContentType] = mediaType
+
+	return doc, nil
+}
+
+// htmlUTF8 converts an HTML page to UTF-8 using the charset from the
+// response, a BOM or a <meta> tag in the page
+func htmlUTF8(resp *http.Response, body []byte) []byte {
+	enc, name, certain := charset.DetermineEncoding(body, resp.Header.Get("Content-Type"))
+	// Only the start of the page is checked when guessing, prefer UTF-8
+	// if the whole page is valid
+	if name == "utf-8" || (!certain && utf8.Valid(body)) {
+		return body
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body
+	}
+
+	return decoded
+}
+
+// textDocument creates a document from plain text
+func textDocument(url string, text string) *Document {
+	title := path.Base(url)
+	if title == "" || title == "/" || title == "." {
+		title = url
+	}
+
+	return &Document{
+		Path:     url,
+		Content:  strings.TrimSpace(text),
+		Title:    title,
+		IsRemote: true,
+	}
+}
+
+// jsonDocument creates a document from JSON, indenting it so that the
+// structure is readable
+func jsonDocument(url string, body []byte) (*Document, error) {
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+
+	return textDocument(url, normalizeText(indented.String())), nil
+}
+
+// pdfDocument extracts the text from a PDF
+func pdfDocument(url string, body []byte) (doc *Document, err error) {
+	// The PDF reader panics on some malformed files
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("read PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("read PDF: %w", err)
+	}
+
+	text, err := reader.GetPlainText()
+	if err != nil {
+		return nil, fmt.Errorf("extract PDF text: %w", err)
+	}
+
+	content, err := io.ReadAll(text)
+	if err != nil {
+		return nil, fmt.Errorf("extract PDF text: %w", err)
+	}
+
+	doc = textDocument(url, normalizeText(string(content)))
+
+	if title := reader.Trailer().Key("Info").Key("Title").Text(); strings.TrimSpace(title) != "" {
+		doc.Title = strings.TrimSpace(title)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,695 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// crawlUserAgent is the user agent used to match robots.txt rules
+const crawlUserAgent = defaultUserAgent
+
+// CrawlOptions controls which pages are visited when crawling a site
+type CrawlOptions struct {
+	// Depth is the number of links to follow from the root page
+	Depth int `json:"depth"`
+	// Sitemap seeds the crawl with the URLs in the site's sitemap.xml
+	Sitemap bool `json:"sitemap,omitempty"`
+	// Include limits the crawl to URLs matching one of these regexps
+	Include []string `json:"include,omitempty"`
+	// Exclude skips URLs matching any of these regexps
+	Exclude []string `json:"exclude,omitempty"`
+	// Concurrency is the number of pages fetched in parallel
+	Concurrency int `json:"concurrency"`
+}
+
+// crawler holds the state for crawling a single site
+type crawler struct {
+	db      *sql.DB
+	root    *url.URL
+	opts    CrawlOptions
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	robots  *robotsRules
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// Crawl fetches the root page and follows same origin links up to the
+// configured depth, adding every page to the database. The crawl root
+// is saved so that it can be crawled again using RecrawlAll.
+func Crawl(ctx context.Context, db *sql.DB, root string, opts CrawlOptions) []error {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = maxParallelEmbeddingRequests
+	}
+
+	rootURL, err := url.Parse(root)
+	if err != nil {
+		return []error{fmt.Errorf("parse URL %s: %w", root, err)}
+	}
+
+	c := &crawler{
+		db:   db,
+		root: rootURL,
+		opts: opts,
+		seen: map[string]bool{},
+	}
+
+	for _, pattern := range opts.Include {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return []error{fmt.Errorf("invalid include pattern %q: %w", pattern, err)}
+		}
+		c.include = append(c.include, re)
+	}
+
+	for _, pattern := range opts.Exclude {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return []error{fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)}
+		}
+		c.exclude = append(c.exclude, re)
+	}
+
+	c.robots = fetchRobots(ctx, rootURL)
+
+	frontier := []string{normalizeCrawlURL(rootURL)}
+	if opts.Sitemap {
+		frontier = append(frontier, c.sitemapURLs(ctx)...)
+	}
+
+	var errors []error
+	for depth := 0; depth <= opts.Depth && len(frontier) > 0 && ctx.Err() == nil; depth++ {
+		next, errs := c.crawlLevel(ctx, frontier, depth < opts.Depth)
+		errors = append(errors, errs...)
+		frontier = next
+	}
+
+	if err := SaveCrawlRoot(db, root, opts); err != nil {
+		errors = append(errors, err)
+	}
+
+	return errors
+}
+
+// crawlLevel fetches all the pages in the frontier in parallel and
+// returns the links found in them if follow is set
+func (c *crawler) crawlLevel(ctx context.Context, frontier []string, follow bool) ([]string, []error) {
+	urlChan := make(chan string, len(frontier))
+	errChan := make(chan error, len(frontier))
+
+	var (
+		mu    sync.Mutex
+		next  []string
+		wg    sync.WaitGroup
+		queue []string
+	)
+
+	for _, u := range frontier {
+		if c.visit(u) {
+			queue = append(queue, u)
+		}
+	}
+	progressFrom(ctx).Discovered(len(queue))
+
+	for i := 0; i < c.opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for u := range urlChan {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				links, err := c.crawlPage(context.WithoutCancel(ctx), u)
+				if err != nil {
+					progressFrom(ctx).Failed()
+					errChan <- fmt.Errorf("%s: %w", u, err)
+					continue
+				}
+
+				if follow {
+					mu.Lock()
+					next = append(next, links...)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, u := range queue {
+		urlChan <- u
+	}
+	close(urlChan)
+
+	wg.Wait()
+	close(errChan)
+
+	var errors []error
+	for err := range errChan {
+		errors = append(errors, err)
+	}
+
+	return next, errors
+}
+
+// visit marks the URL as seen and reports if it should be crawled
+func (c *crawler) visit(u string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen[u] {
+		return false
+	}
+	c.seen[u] = true
+
+	return c.inScope(u)
+}
+
+// inScope checks if the URL is on the same origin as the root and is
+// allowed by robots.txt and the include/exclude patterns
+func (c *crawler) inScope(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+
+	if parsed.Scheme != c.root.Scheme || parsed.Host != c.root.Host {
+		return false
+	}
+
+	if !c.robots.allowed(parsed.RequestURI()) {
+		return false
+	}
+
+	for _, re := range c.exclude {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+
+	if len(c.include) == 0 {
+		return true
+	}
+
+	for _, re := range c.include {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+
+	// The root is always crawled so that links can be discovered from it
+	return u == normalizeCrawlURL(c.root)
+}
+
+// crawlPage adds a single page to the database and returns the links
+// found in it. Pages which were crawled before are only fetched if they
+// might have changed, in which case the links recorded for them are
+// followed.
+func (c *crawler) crawlPage(ctx context.Context, u string) ([]string, error) {
+	progress := progressFrom(ctx)
+
+	previous := GetDocumentByPath(c.db, u)
+	links, err := getDocumentOutgoingLinks(c.db, u)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pages crawled before their links were recorded are fetched again
+	// so that the links can be followed. Almost every page links
+	// somewhere, so this only happens once for a page.
+	if previous != nil && isHTMLDocument(previous) && len(links) == 0 {
+		previous = nil
+	}
+
+	if fetchedRecently(previous) {
+		progress.Skipped()
+		return linkTargets(links), nil
+	}
+
+	req, client, err := newRemoteRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return nil, err
+	}
+	setConditionalHeaders(req, previous)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch URL %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && previous != nil {
+		// Store the page again so that the new fetch time is saved
+		doc := *previous
+		doc.Metadata = maps.Clone(previous.Metadata)
+		setHTTPMetadata(&doc, resp)
+		if err := storeDocument(ctx, c.db, &doc); err != nil {
+			return nil, err
+		}
+
+		return linkTargets(links), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, URL: u}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	progress.Fetched()
+
+	// Pages like images and archives that cannot be indexed are skipped
+	doc, err := remoteDocument(u, resp, body)
+	if errors.Is(err, ErrUnsupportedContentType) {
+		progress.Skipped()
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	setHTTPMetadata(doc, resp)
+
+	if err := storeDocument(ctx, c.db, doc); err != nil {
+		return nil, err
+	}
+
+	// Only HTML pages are followed
+	if !isHTMLDocument(doc) {
+		return nil, nil
+	}
+
+	// The links are recorded so that pages which have not changed do
+	// not have to be fetched to be followed on a recrawl
+	found := extractLinks(resp.Request.URL, body)
+	links = make([]Link, 0, len(found))
+	for _, link := range found {
+		links = append(links, Link{Target: link, Kind: LinkKindPath})
+	}
+
+	err = writerFor(c.db).write(func(tx *sql.Tx) error {
+		return saveDocumentLinks(tx, u, links)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// isHTMLDocument reports if a document was converted from an HTML page
+func isHTMLDocument(doc *Document) bool {
+	contentType := doc.Metadata[metadataContentType]
+	return contentType == "text/html" || contentType == "application/xhtml+xml"
+}
+
+// linkTargets returns the targets of the links
+func linkTargets(links []Link) []string {
+	targets := make([]string, 0, len(links))
+	for _, link := range links {
+		targets = append(targets, link.Target)
+	}
+
+	return targets
+}
+
+// sitemapURLs returns the URLs listed in the sitemap of the site.
+// Sitemap indexes are followed one level deep.
+func (c *crawler) sitemapURLs(ctx context.Context) []string {
+	sitemaps := c.robots.sitemaps
+	if len(sitemaps) == 0 {
+		sitemaps = []string{c.root.Scheme + "://" + c.root.Host + "/sitemap.xml"}
+	}
+
+	var urls []string
+	for _, sitemap := range sitemaps {
+		locs, nested := fetchSitemap(ctx, sitemap)
+		urls = append(urls, locs...)
+
+		for _, n := range nested {
+			locs, _ := fetchSitemap(ctx, n)
+			urls = append(urls, locs...)
+		}
+	}
+
+	for i, u := range urls {
+		if parsed, err := url.Parse(u); err == nil {
+			urls[i] = normalizeCrawlURL(parsed)
+		}
+	}
+
+	return urls
+}
+
+// fetchSitemap returns the page URLs and nested sitemap URLs listed in
+// a sitemap. Errors are ignored as sitemaps are optional.
+func fetchSitemap(ctx context.Context, sitemapURL string) ([]string, []string) {
+	req, client, err := newRemoteRequest(ctx, http.MethodGet, sitemapURL)
+	if err != nil {
+		return nil, nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+
+	var sitemap struct {
+		URLs []struct {
+			Loc string `xml:"loc"`
+		} `xml:"url"`
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&sitemap); err != nil {
+		return nil, nil
+	}
+
+	var urls, nested []string
+	for _, u := range sitemap.URLs {
+		urls = append(urls, strings.TrimSpace(u.Loc))
+	}
+	for _, s := range sitemap.Sitemaps {
+		nested = append(nested, strings.TrimSpace(s.Loc))
+	}
+
+	return urls, nested
+}
+
+// extractLinks returns the absolute URLs of all the links in a page
+func extractLinks(base *url.URL, body []byte) []string {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, attr := range n.Attr {
+				if attr.Key != "href" {
+					continue
+				}
+
+				ref, err := url.Parse(strings.TrimSpace(attr.Val))
+				if err != nil {
+					continue
+				}
+
+				resolved := base.ResolveReference(ref)
+				if resolved.Scheme == "http" || resolved.Scheme == "https" {
+					links = append(links, normalizeCrawlURL(resolved))
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return links
+}
+
+// normalizeCrawlURL drops the fragment so that anchors within a page
+// are not crawled as separate pages
+func normalizeCrawlURL(u *url.URL) string {
+	normalized := *u
+	normalized.Fragment = ""
+	normalized.RawFragment = ""
+	if normalized.Path == "" {
+		normalized.Path = "/"
+	}
+	return normalized.String()
+}
+
+// robotsRules are the robots.txt rules that apply to refer
+type robotsRules struct {
+	rules    []robotsRule
+	sitemaps []string
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern *regexp.Regexp
+	length  int
+}
+
+// fetchRobots fetches and parses robots.txt for the site. A missing or
+// unreadable robots.txt allows everything.
+func fetchRobots(ctx context.Context, root *url.URL) *robotsRules {
+	robotsURL := root.Scheme + "://" + root.Host + "/robots.txt"
+
+	req, client, err := newRemoteRequest(ctx, http.MethodGet, robotsURL)
+	if err != nil {
+		return &robotsRules{}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return &robotsRules{}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &robotsRules{}
+	}
+
+	return parseRobots(resp.Body)
+}
+
+// crawlProductToken returns the name refer is matched by in the
+// User-agent lines of robots.txt
+func crawlProductToken() string {
+	token, _, _ := strings.Cut(crawlUserAgent, "/")
+	return strings.ToLower(token)
+}
+
+// parseRobots parses robots.txt keeping the rules for the refer user
+// agent, falling back to the rules for all agents
+func parseRobots(r io.Reader) *robotsRules {
+	var (
+		specific, generic []robotsRule
+		sitemaps          []string
+		agents            []string
+		inRules           bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			// A user-agent line after rules starts a new group
+			if inRules {
+				agents = nil
+				inRules = false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				continue
+			}
+
+			rule := robotsRule{
+				allow:   key == "allow",
+				pattern: robotsPattern(value),
+				length:  len(value),
+			}
+
+			for _, agent := range agents {
+				switch {
+				case agent == "*":
+					generic = append(generic, rule)
+				case agent != "" && agent == crawlProductToken():
+					specific = append(specific, rule)
+				}
+			}
+		case "sitemap":
+			sitemaps = append(sitemaps, value)
+		}
+	}
+
+	rules := generic
+	if len(specific) > 0 {
+		rules = specific
+	}
+
+	return &robotsRules{rules: rules, sitemaps: sitemaps}
+}
+
+// robotsPattern converts a robots.txt path pattern with * and $ into a
+// regexp
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+// allowed checks if the path can be crawled. The longest matching rule
+// wins and allow wins ties.
+func (r *robotsRules) allowed(path string) bool {
+	allowed := true
+	longest := -1
+	for _, rule := range r.rules {
+		if !rule.pattern.MatchString(path) {
+			continue
+		}
+
+		if rule.length > longest || (rule.length == longest && rule.allow) {
+			allowed = rule.allow
+			longest = rule.length
+		}
+	}
+
+	return allowed
+}
+
+// CrawlRoot is a site that was added by crawling
+type CrawlRoot struct {
+	URL         string
+	Options     CrawlOptions
+	LastCrawled time.Time
+}
+
+// SaveCrawlRoot records the crawl root and options so that it can be
+// crawled again later
+func SaveCrawlRoot(db *sql.DB, root string, opts CrawlOptions) error {
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return fmt.Errorf("marshal crawl options: %w", err)
+	}
+
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		_, err := tx.Exec(
+			"INSERT OR REPLACE INTO crawl_roots (url, options, last_crawled) VALUES (?, ?, ?)",
+			root, string(options), time.Now().UTC().Format(time.RFC3339))
+		if err != nil {
+			return fmt.Errorf("save crawl root %s: %w", root, err)
+		}
+
+		return nil
+	})
+}
+
+// GetCrawlRoots returns all the sites that were added by crawling
+func GetCrawlRoots(db *sql.DB) ([]CrawlRoot, error) {
+	rows, err := db.Query("SELECT url, options, last_crawled FROM crawl_roots")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query crawl roots: %v", err)
+	}
+	defer rows.Close()
+
+	var roots []CrawlRoot
+	for rows.Next() {
+		var root CrawlRoot
+		var options, lastCrawled string
+		if err := rows.Scan(&root.URL, &options, &lastCrawled); err != nil {
+			return nil, fmt.Errorf("failed to scan crawl root: %v", err)
+		}
+
+		if err := json.Unmarshal([]byte(options), &root.Options); err != nil {
+			return nil, fmt.Errorf("invalid crawl options for %s: %v", root.URL, err)
+		}
+
+		root.LastCrawled, _ = time.Parse(time.RFC3339, lastCrawled)
+		roots = append(roots, root)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating crawl roots: %v", err)
+	}
+
+	return roots, nil
+}
+
+// RecrawlAll crawls all the saved crawl roots again, picking up new
+// and changed pages
+func RecrawlAll(ctx context.Context, db *sql.DB) []error {
+	roots, err := GetCrawlRoots(db)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errors []error
+	for _, root := range roots {
+		if ctx.Err() != nil {
+			break
+		}
+
+		errors = append(errors, Crawl(ctx, db, root.URL, root.Options)...)
+	}
+
+	return errors
+}
+
+// CopyCrawlRoots copies the saved crawl roots to another database
+func CopyCrawlRoots(src, dst *sql.DB) error {
+	roots, err := GetCrawlRoots(src)
+	if err != nil {
+		return err
+	}
+
+	return writerFor(dst).write(func(tx *sql.Tx) error {
+		for _, root := range roots {
+			options, err := json.Marshal(root.Options)
+			if err != nil {
+				return fmt.Errorf("marshal crawl options: %w", err)
+			}
+
+			_, err = tx.Exec(
+				"INSERT OR REPLACE INTO crawl_roots (url, options, last_crawled) VALUES (?, ?, ?)",
+				root.URL, string(options), root.LastCrawled.Format(time.RFC3339))
+			if err != nil {
+				return fmt.Errorf("copy crawl root %s: %w", root.URL, err)
+			}
+		}
+
+		return nil
+	})
+}
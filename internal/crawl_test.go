@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecrawlOnlyFetchesChangedPages(t *testing.T) {
+	var fetched, notModified atomic.Int32
+	mux := http.NewServeMux()
+
+	page := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			fetched.Add(1)
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/{$}", page(`<html><body><main><p>Welcome to the documentation</p>
+<a href="/guide">Guide</a></main></body></html>`))
+	mux.HandleFunc("/guide", page(`<html><body><main><p>How to get started</p>
+<a href="/">Home</a></main></body></html>`))
+
+	db, _, serverURL := newTestDatabase(t, mux)
+	opts := CrawlOptions{Depth: 1, Concurrency: 1}
+
+	if errs := Crawl(context.Background(), db, serverURL+"/", opts); len(errs) != 0 {
+		t.Fatalf("crawl: %v", errs)
+	}
+	if got := fetched.Load(); got != 2 {
+		t.Fatalf("first crawl fetched %d pages, want 2", got)
+	}
+
+	progress, err := NewProgress(ProgressNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := WithProgress(context.Background(), progress)
+	if errs := Crawl(ctx, db, serverURL+"/", opts); len(errs) != 0 {
+		t.Fatalf("recrawl: %v", errs)
+	}
+	progress.Stop()
+
+	if got := fetched.Load(); got != 2 {
+		t.Errorf("recrawl fetched %d pages again, want none", got)
+	}
+	// The guide is only reachable through the links recorded for the root
+	if got := notModified.Load(); got != 2 {
+		t.Errorf("recrawl made %d conditional requests, want 2", got)
+	}
+
+	if progress.discovered != 2 || progress.skipped != 2 || progress.embedded != 0 {
+		t.Errorf("recrawl progress: discovered %d, skipped %d, embedded %d, want 2, 2, 0",
+			progress.discovered, progress.skipped, progress.embedded)
+	}
+}
+
+func TestParseRobotsUserAgent(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		allowed bool
+	}{
+		{
+			name:    "empty user agent",
+			robots:  "User-agent:\nDisallow: /\n\nUser-agent: *\nAllow: /\n",
+			allowed: true,
+		},
+		{
+			name:    "other user agent",
+			robots:  "User-agent: preferbot\nDisallow: /\n",
+			allowed: true,
+		},
+		{
+			name:    "product token",
+			robots:  "User-agent: Refer\nDisallow: /\n\nUser-agent: *\nAllow: /\n",
+			allowed: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules := parseRobots(strings.NewReader(tt.robots))
+			if got := rules.allowed("/docs"); got != tt.allowed {
+				t.Errorf("allowed(/docs) = %v, want %v", got, tt.allowed)
+			}
+		})
+	}
+}
@@ -16,13 +16,32 @@ type Document struct {
 	Title    string
 	IsRemote bool
 
+	// Extra information about the document (eg: front matter fields)
+	Metadata map[string]string
+	// Outgoing links to other documents
+	Links []Link
+
 	// Only used for search results
 	Distance float64
 }
 
+// Chunk is a part of a document that is embedded separately. Chunks are
+// stored in the chunks table and their embeddings in the
+// chunk_embeddings vec0 table using the chunk id as rowid.
+type Chunk struct {
+	Content   string
+	Embedding []byte
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx so that helpers can
+// write either directly or as part of a transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
 // GetAllDocuments retrieves all documents from the database
 func GetAllDocuments(db *sql.DB) ([]Document, error) {
-	rows, err := db.Query("SELECT rowid, filepath, content, title FROM documents")
+	rows, err := db.Query("SELECT id, filepath, content, title FROM documents ORDER BY id")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query documents: %v", err)
 	}
@@ -65,14 +84,33 @@ func GetAllFilePaths(db *sql.DB) ([]string, error) {
 	return filepaths, nil
 }
 
-func GetDocumentEmbedding(db *sql.DB, id int64) ([]byte, error) {
-	var embedding []byte
-	err := db.QueryRow("SELECT embedding FROM documents WHERE rowid = ?", id).Scan(&embedding)
+// GetDocumentChunks retrieves the chunks of a document along with their
+// embeddings
+func GetDocumentChunks(db *sql.DB, id int64) ([]Chunk, error) {
+	rows, err := db.Query(`
+		SELECT chunks.content, chunk_embeddings.embedding
+		FROM chunks
+		JOIN chunk_embeddings ON chunk_embeddings.rowid = chunks.id
+		WHERE chunks.document_id = ?
+		ORDER BY chunks.seq`, id)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query embedding: %v", err)
+		return nil, fmt.Errorf("failed to query chunks: %v", err)
 	}
+	defer rows.Close()
 
-	return embedding, nil
+	var chunks []Chunk
+	for rows.Next() {
+		var chunk Chunk
+		if err := rows.Scan(&chunk.Content, &chunk.Embedding); err != nil {
+			return nil, fmt.Errorf("failed to scan chunk: %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating chunks: %v", err)
+	}
+
+	return chunks, nil
 }
 
 // CreateDB creates or opens a SQLite database at the given path.
@@ -82,7 +120,12 @@ func CreateDB(dbPath string) (*sql.DB, bool, error) {
 	sqlite_vec.Auto() // Ensure sqlite-vec is loaded
 
 	isNew := !fileExists(dbPath)
-	db, err := sql.Open("sqlite3", dbPath)
+	// WAL lets searches read while documents are being written and the
+	// busy timeout makes other processes wait for the lock instead of
+	// failing right away. Transactions take the write lock when they
+	// begin so that they cannot deadlock upgrading from a read lock.
+	db, err := sql.Open("sqlite3", dbPath+
+		"?_foreign_keys=on&_journal_mode=WAL&_busy_timeout=10000&_txlock=immediate")
 	if err != nil {
 		return nil, false, fmt.Errorf("open database %s: %w", dbPath, err)
 	}
@@ -90,6 +133,23 @@ func CreateDB(dbPath string) (*sql.DB, bool, error) {
 	return db, isNew, nil
 }
 
+// DiscardDatabase closes a database and removes its files. It is used
+// for databases that were created but could not be filled, which would
+// otherwise be left behind without a schema.
+func DiscardDatabase(db *sql.DB, dbPath string) error {
+	if err := closeDatabase(db); err != nil {
+		return fmt.Errorf("close database: %w", err)
+	}
+
+	return removeDatabaseFiles(dbPath)
+}
+
+// closeDatabase stops the writer of a database and closes it
+func closeDatabase(db *sql.DB) error {
+	closeWriter(db)
+	return db.Close()
+}
+
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return !os.IsNotExist(err)
@@ -97,58 +157,92 @@ func fileExists(path string) bool {
 
 // InitDatabase initializes the database schema with the required tables
 func InitDatabase(db *sql.DB, embeddingSize int) error {
-	query := fmt.Sprintf(`
-		CREATE VIRTUAL TABLE IF NOT EXISTS documents USING vec0(
-			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
-			filepath TEXT UNIQUE,
-			content TEXT,
-			title TEXT,
-			embedding float[%d]
-		)
-	`, embeddingSize)
+	// The embedding size is needed by the migrations to create the
+	// vector table
+	err := writerFor(db).write(func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS config (
+				key TEXT PRIMARY KEY,
+				value TEXT
+			)`); err != nil {
+			return fmt.Errorf("create config table: %w", err)
+		}
+
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO config (key, value) VALUES ('embedding_size', ?)",
+			fmt.Sprintf("%d", embeddingSize)); err != nil {
+			return fmt.Errorf("save embedding size: %w", err)
+		}
 
-	if _, err := db.Exec(query); err != nil {
-		return fmt.Errorf("create documents table: %w", err)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS config (
-			key TEXT PRIMARY KEY,
-			value TEXT
-		)`); err != nil {
-		return fmt.Errorf("create config table: %w", err)
+	if _, _, err := Migrate(db); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// SaveConfig saves configuration key-value pairs to the database
-func SaveConfig(db *sql.DB, config map[string]string) error {
-	tx, err := db.Begin()
+// GetDocumentMetadata retrieves the metadata stored for a document
+func GetDocumentMetadata(db *sql.DB, path string) (map[string]string, error) {
+	rows, err := db.Query("SELECT key, value FROM document_metadata WHERE filepath = ?", path)
 	if err != nil {
-		return fmt.Errorf("begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to query metadata: %v", err)
 	}
-	defer tx.Rollback()
+	defer rows.Close()
 
-	stmt, err := tx.Prepare("INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)")
-	if err != nil {
-		return fmt.Errorf("prepare statement: %w", err)
+	metadata := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan metadata: %v", err)
+		}
+		metadata[key] = value
 	}
-	defer stmt.Close()
 
-	for key, value := range config {
-		if _, err := stmt.Exec(key, value); err != nil {
-			return fmt.Errorf("insert config %s: %w", key, err)
-		}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating metadata: %v", err)
+	}
+
+	return metadata, nil
+}
+
+// saveDocumentMetadata replaces the metadata stored for a document
+func saveDocumentMetadata(db execer, path string, metadata map[string]string) error {
+	if _, err := db.Exec("DELETE FROM document_metadata WHERE filepath = ?", path); err != nil {
+		return fmt.Errorf("delete existing metadata: %w", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit transaction: %w", err)
+	for key, value := range metadata {
+		if _, err := db.Exec(
+			"INSERT INTO document_metadata (filepath, key, value) VALUES (?, ?, ?)",
+			path, key, value); err != nil {
+			return fmt.Errorf("insert metadata %s: %w", key, err)
+		}
 	}
 
 	return nil
 }
 
+// SaveConfig saves configuration key-value pairs to the database
+func SaveConfig(db *sql.DB, config map[string]string) error {
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		for key, value := range config {
+			if _, err := tx.Exec(
+				"INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)",
+				key, value); err != nil {
+				return fmt.Errorf("insert config %s: %w", key, err)
+			}
+		}
+
+		return nil
+	})
+}
+
 func GetConfig(db *sql.DB) (map[string]string, error) {
 	rows, err := db.Query("SELECT key, value FROM config")
 	if err != nil {
@@ -184,19 +278,28 @@ func SearchDocuments(
 		return nil, fmt.Errorf("serialize query: %w", err)
 	}
 
+	// Documents can have multiple chunks, fetch more of them so that
+	// there are enough distinct documents after picking the closest
+	// chunk of each
 	baseQuery := `
+	WITH matches AS (
+		SELECT rowid, distance
+		FROM chunk_embeddings
+		WHERE embedding MATCH ? AND k = ?
+	)
 	SELECT
-		rowid,
-		filepath,
-		content,
-		title,
-		distance
-	FROM documents
-	WHERE embedding match ?
-	ORDER BY distance LIMIT ?
+		documents.id,
+		documents.filepath,
+		documents.content,
+		documents.title,
+		matches.distance
+	FROM matches
+	JOIN chunks ON chunks.id = matches.rowid
+	JOIN documents ON documents.id = chunks.document_id
+	ORDER BY matches.distance
 `
 
-	rows, err := db.Query(baseQuery, serializedQuery, limit)
+	rows, err := db.Query(baseQuery, serializedQuery, min(limit*searchOverfetch, maxSearchChunks))
 
 	if err != nil {
 		return nil, fmt.Errorf("execute search: %w", err)
@@ -209,14 +312,15 @@ func SearchDocuments(
 	defer rows.Close()
 
 	documents := make([]Document, 0)
+	seen := map[int64]bool{}
 
 	for rows.Next() {
-		var rowid int
+		var id int64
 		var filepath string
 		var content, title string
 		var distance float64
 
-		if err := rows.Scan(&rowid, &filepath, &content, &title, &distance); err != nil {
+		if err := rows.Scan(&id, &filepath, &content, &title, &distance); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
 
@@ -225,8 +329,15 @@ func SearchDocuments(
 			continue
 		}
 
+		// Rows are sorted by distance, so the first chunk of a document
+		// is its closest one
+		if seen[id] || len(documents) >= limit {
+			continue
+		}
+		seen[id] = true
+
 		documents = append(documents, Document{
-			ID:       int64(rowid),
+			ID:       id,
 			Path:     filepath,
 			Content:  content,
 			Title:    title,
@@ -245,15 +356,21 @@ func SearchDocuments(
 func GetDocumentByID(db *sql.DB, id int) (*Document, error) {
 	var doc Document
 	err := db.QueryRow(`
-		SELECT rowid, filepath, content, title
+		SELECT id, filepath, content, title
 		FROM documents
-		WHERE rowid = ?`, id).Scan(&doc.ID, &doc.Path, &doc.Content, &doc.Title)
+		WHERE id = ?`, id).Scan(&doc.ID, &doc.Path, &doc.Content, &doc.Title)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query document: %w", err)
 	}
+
+	doc.Metadata, err = GetDocumentMetadata(db, doc.Path)
+	if err != nil {
+		return nil, err
+	}
+
 	return &doc, nil
 }
 
@@ -261,7 +378,7 @@ func GetDocumentByID(db *sql.DB, id int) (*Document, error) {
 func GetDocumentByPath(db *sql.DB, path string) *Document {
 	var doc Document
 	err := db.QueryRow(`
-		SELECT rowid, filepath, content, title
+		SELECT id, filepath, content, title
 		FROM documents
 		WHERE filepath = ?`, path).Scan(&doc.ID, &doc.Path, &doc.Content, &doc.Title)
 	if err == sql.ErrNoRows {
@@ -270,23 +387,65 @@ func GetDocumentByPath(db *sql.DB, path string) *Document {
 	if err != nil {
 		return nil
 	}
+
+	doc.Metadata, err = GetDocumentMetadata(db, doc.Path)
+	if err != nil {
+		return nil
+	}
+
 	return &doc
 }
 
 // RemoveDocument removes a document by its ID
 func RemoveDocument(db *sql.DB, id int) error {
-	result, err := db.Exec("DELETE FROM documents WHERE rowid = ?", id)
+	var path string
+	err := db.QueryRow("SELECT filepath FROM documents WHERE id = ?", id).Scan(&path)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no document found with ID %d", id)
+	}
 	if err != nil {
+		return fmt.Errorf("failed to query document: %v", err)
+	}
+
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		return deleteDocument(tx, int64(id), path)
+	})
+}
+
+// deleteDocument removes a document along with its chunks, metadata and
+// links
+func deleteDocument(tx execer, id int64, path string) error {
+	if err := deleteDocumentChunks(tx, id); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM documents WHERE id = ?", id); err != nil {
 		return fmt.Errorf("failed to remove document: %v", err)
 	}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %v", err)
+	if _, err := tx.Exec("DELETE FROM document_metadata WHERE filepath = ?", path); err != nil {
+		return fmt.Errorf("failed to remove document metadata: %v", err)
 	}
 
-	if rows == 0 {
-		return fmt.Errorf("no document found with ID %d", id)
+	if _, err := tx.Exec("DELETE FROM document_links WHERE source = ?", path); err != nil {
+		return fmt.Errorf("failed to remove document links: %v", err)
+	}
+
+	return nil
+}
+
+// deleteDocumentChunks removes the chunks of a document and their
+// embeddings. The vec0 table does not support foreign keys, so the
+// embeddings have to be removed explicitly.
+func deleteDocumentChunks(tx execer, id int64) error {
+	if _, err := tx.Exec(`
+		DELETE FROM chunk_embeddings
+		WHERE rowid IN (SELECT id FROM chunks WHERE document_id = ?)`, id); err != nil {
+		return fmt.Errorf("delete chunk embeddings: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM chunks WHERE document_id = ?", id); err != nil {
+		return fmt.Errorf("delete chunks: %w", err)
 	}
 
 	return nil
@@ -303,6 +462,14 @@ func GetDatabaseStats(db *sql.DB) (map[string]int, error) {
 	}
 	stats["documents"] = docCount
 
+	// Get total number of chunks
+	var chunkCount int
+	err = db.QueryRow("SELECT COUNT(*) FROM chunks").Scan(&chunkCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count chunks: %v", err)
+	}
+	stats["chunks"] = chunkCount
+
 	// Get total size of all documents
 	var totalSize int
 	err = db.QueryRow("SELECT COALESCE(SUM(LENGTH(content)), 0) FROM documents").Scan(&totalSize)
@@ -322,16 +489,24 @@ func RecreateDatabase(db *sql.DB, embeddingSize int) ([]string, error) {
 		return nil, fmt.Errorf("failed to get existing documents: %v", err)
 	}
 
-	// Drop the existing table
-	_, err = db.Exec("DROP TABLE IF EXISTS documents")
-	if err != nil {
-		return nil, fmt.Errorf("failed to drop existing table: %v", err)
-	}
+	err = writerFor(db).write(func(tx *sql.Tx) error {
+		tables := []string{
+			// Document tables
+			"chunk_embeddings", "chunks", "documents",
+			"config", "document_metadata", "document_links", "crawl_roots",
+			// Feed tables
+			"feeds", "feed_entries",
+		}
+		for _, table := range tables {
+			if _, err := tx.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+				return fmt.Errorf("failed to drop %s table: %v", table, err)
+			}
+		}
 
-	// Drop the config table
-	_, err = db.Exec("DROP TABLE IF EXISTS config")
+		return nil
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to drop config table: %v", err)
+		return nil, err
 	}
 
 	// Initialize new database with current schema
@@ -3,13 +3,17 @@ package internal
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5"
@@ -22,12 +26,101 @@ import (
 
 const maxParallelEmbeddingRequests = 10
 
+// maxChunkLength is the maximum length in bytes of the text embedded
+// for a chunk. Longer documents are split so that every part of them
+// can be found and they are not cut off by the context size of the
+// embedding model.
+const maxChunkLength = 2000
+
+const (
+	// searchOverfetch is the number of chunks fetched per requested
+	// search result
+	searchOverfetch = 4
+	// maxSearchChunks is the largest k supported by vec0
+	maxSearchChunks = 4096
+)
+
+// Metadata keys used to make conditional requests for remote documents
+const (
+	metadataETag         = "http_etag"
+	metadataLastModified = "http_last_modified"
+	metadataFetchedAt    = "fetched_at"
+	metadataContentType  = "content_type"
+)
+
+// RemoteRefreshInterval is the minimum time between two fetches of the
+// same remote document when reindexing
+var RemoteRefreshInterval time.Duration
+
+// ErrNotModified is returned when a document has not changed since it
+// was last fetched
+var ErrNotModified = errors.New("document not modified")
+
 // FetchDocument retrieves content from either a local file or remote URL
 func FetchDocument(path string) (*Document, error) {
 	if IsRemoteURL(path) {
 		return fetchRemoteDocument(path)
 	}
-	return fetchLocalDocument(path)
+	if isFeedEntryPath(path) {
+		return fetchFeedEntry(path)
+	}
+	if isExecPath(path) {
+		return nil, fmt.Errorf("cannot run %s, add the command with --exec", path)
+	}
+	if isStdinPath(path) {
+		return nil, fmt.Errorf("cannot read %s again, add it from stdin", path)
+	}
+	if isGitLogPath(path) {
+		return fetchGitCommit(path)
+	}
+	if IsGitTreeURL(path) {
+		return fetchGitTreeFile(path)
+	}
+	return fetchLocalPath(path)
+}
+
+// fetchLocalPath reads a document from disk. The stored path is resolved
+// for reading and the document is named by the stored path.
+func fetchLocalPath(path string) (*Document, error) {
+	resolved := ResolvePath(path)
+
+	var (
+		doc *Document
+		err error
+	)
+	if archive, name, ok := splitArchivePath(resolved); ok {
+		doc, err = fetchArchiveFile(resolved, archive, name)
+	} else if file, key, ok := splitMboxPath(resolved); ok {
+		doc, err = fetchMboxMessage(resolved, file, key)
+	} else if isMaildirMessage(resolved) {
+		doc, err = fetchMaildirMessage(resolved)
+	} else {
+		doc, err = fetchLocalDocument(resolved)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	storeDocumentPaths(doc, resolved, path)
+
+	return doc, nil
+}
+
+// ExpandPath returns the paths of the documents contained in a local
+// file. Most files are a single document, but an mbox file holds one
+// document per message and an archive one per text file in it. Files in
+// archives larger than maxSize are skipped if it is set.
+func ExpandPath(path string, maxSize int64) ([]string, error) {
+	if isMaildirTemporary(path) {
+		return nil, nil
+	}
+	if isArchiveFile(path) {
+		return expandArchive(path, maxSize)
+	}
+	if isMboxFile(path) {
+		return expandMbox(path)
+	}
+	return []string{path}, nil
 }
 
 // IsRemoteURL checks if the given path is a remote URL
@@ -35,16 +128,123 @@ func IsRemoteURL(path string) bool {
 	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
 }
 
+// FetchDocumentIfModified retrieves a document only if it has changed
+// since the previous version was fetched. For remote documents this
+// uses conditional requests based on the stored ETag and Last-Modified
+// values and skips the request altogether if the document was fetched
+// within RemoteRefreshInterval. If the server responds that the document
+// has not changed, the previous version is returned with the fetch time
+// and validators updated so that they are saved. Commands added with
+// --exec are run again, while documents read from stdin are always
+// current. ErrNotModified is returned if the previous version is still
+// current.
+func FetchDocumentIfModified(path string, previous *Document) (*Document, error) {
+	if isExecPath(path) && previous != nil {
+		command := previous.Metadata[metadataCommand]
+		if command == "" {
+			return nil, ErrNotModified
+		}
+		return fetchCommandOutput(command)
+	}
+
+	if isStdinPath(path) && previous != nil {
+		return nil, ErrNotModified
+	}
+
+	if isGitLogPath(path) && previous != nil {
+		return refetchGitCommit(path, previous)
+	}
+
+	// Feeds only list recent entries, the indexed version of older ones
+	// is kept
+	if isFeedEntryPath(path) && previous != nil {
+		doc, err := fetchFeedEntry(path)
+		if errors.Is(err, errFeedEntryGone) {
+			return nil, ErrNotModified
+		}
+		return doc, err
+	}
+
+	if !IsRemoteURL(path) || previous == nil {
+		return FetchDocument(path)
+	}
+
+	if fetchedRecently(previous) {
+		return nil, ErrNotModified
+	}
+
+	return fetchRemoteDocumentConditional(path, previous)
+}
+
+// fetchedRecently reports if a web page was fetched within
+// RemoteRefreshInterval and does not have to be requested again
+func fetchedRecently(previous *Document) bool {
+	if previous == nil || RemoteRefreshInterval <= 0 {
+		return false
+	}
+
+	fetchedAt, err := time.Parse(time.RFC3339, previous.Metadata[metadataFetchedAt])
+	return err == nil && time.Since(fetchedAt) < RemoteRefreshInterval
+}
+
+// keepSourceMetadata carries the metadata of the previous version of a
+// web page that came from the bookmark or feed it was added from over to
+// a newly fetched one
+func keepSourceMetadata(doc, previous *Document) {
+	if previous == nil || !doc.IsRemote {
+		return
+	}
+
+	applyBookmarkMetadata(doc, previous.Metadata)
+	keepFeedMetadata(doc, previous)
+}
+
+// httpStatusError is returned for responses with a status other than
+// the expected one
+type httpStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.URL)
+}
+
 // fetchRemoteDocument fetches and processes a remote document
 func fetchRemoteDocument(url string) (*Document, error) {
-	resp, err := http.Get(url)
+	return fetchRemoteDocumentConditional(url, nil)
+}
+
+// fetchRemoteDocumentConditional fetches a remote document sending the
+// validators from the previous version. If the server responds with 304
+// the previous version is returned with updated HTTP metadata.
+func fetchRemoteDocumentConditional(url string, previous *Document) (*Document, error) {
+	req, client, err := newRemoteRequest(context.Background(), http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+
+	setConditionalHeaders(req, previous)
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch URL %s: %w", url, err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		if previous == nil {
+			return nil, ErrNotModified
+		}
+
+		doc := *previous
+		doc.Metadata = maps.Clone(previous.Metadata)
+		setHTTPMetadata(&doc, resp)
+		return &doc, nil
+	}
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, url)
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, URL: url}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -52,8 +252,54 @@ func fetchRemoteDocument(url string) (*Document, error) {
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
+	doc, err := remoteDocument(url, resp, body)
+	if err != nil {
+		return nil, err
+	}
+
+	setHTTPMetadata(doc, resp)
+	return doc, nil
+}
+
+// setConditionalHeaders makes a request conditional on the validators
+// stored for the previous version of the page
+func setConditionalHeaders(req *http.Request, previous *Document) {
+	if previous == nil {
+		return
+	}
+
+	if etag := previous.Metadata[metadataETag]; etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := previous.Metadata[metadataLastModified]; lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// setHTTPMetadata records the cache validators of a response so that
+// later fetches can be made conditional
+func setHTTPMetadata(doc *Document, resp *http.Response) {
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		doc.Metadata[metadataETag] = etag
+	}
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		doc.Metadata[metadataLastModified] = lastModified
+	}
+	doc.Metadata[metadataFetchedAt] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// htmlDocument converts an HTML page into a document
+func htmlDocument(url string, body []byte) (*Document, error) {
+	// Strip navigation, banners and other boilerplate so that they do
+	// not dominate the embedding
+	mainContent, metadata := extractMainContent(body)
+
 	converter := md.NewConverter("", true, nil)
-	content, err := converter.ConvertString(string(body))
+	content, err := converter.ConvertString(mainContent)
 	if err != nil {
 		return nil, fmt.Errorf("convert HTML to markdown: %w", err)
 	}
@@ -65,6 +311,10 @@ func fetchRemoteDocument(url string) (*Document, error) {
 		IsRemote: true,
 	}
 
+	if len(metadata) > 0 {
+		doc.Metadata = metadata
+	}
+
 	if doc.Title == "" {
 		doc.Title = url
 	}
@@ -78,17 +328,50 @@ func fetchLocalDocument(path string) (*Document, error) {
 		return nil, err
 	}
 
-	content, err := os.ReadFile(path)
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read file %s: %w", path, err)
 	}
 
-	return &Document{
+	content, ok := decodeText(raw)
+	if !ok {
+		return nil, fmt.Errorf("not a text file: %s", path)
+	}
+
+	doc := &Document{
 		Path:     path,
-		Content:  string(content),
+		Content:  content,
 		Title:    path,
 		IsRemote: false,
-	}, nil
+	}
+
+	if isMarkdownFile(path) {
+		parseMarkdownDocument(doc)
+	}
+
+	return doc, nil
+}
+
+// EmbeddingText returns the text that should be used to generate the
+// embedding for a document
+func EmbeddingText(doc *Document) string {
+	if !doc.IsRemote && isMailPath(doc.Path) {
+		return doc.Title + "\n\n" + doc.Content
+	}
+
+	if isMarkdownDocument(doc) {
+		if text := markdownEmbeddingText(doc.Content); text != "" {
+			return text
+		}
+	}
+
+	return doc.Content
+}
+
+// isMarkdownDocument checks if a document is a markdown file or a
+// markdown page
+func isMarkdownDocument(doc *Document) bool {
+	return isMarkdownFile(doc.Path) || doc.Metadata[metadataContentType] == "text/markdown"
 }
 
 // validateLocalFile checks if a local file is valid for processing
@@ -137,7 +420,10 @@ func LoadGitignorePatterns(gitDir string) ([]gitignore.Pattern, error) {
 	return patterns, nil
 }
 
-func LoadGitignoreFromFile(path string) ([]gitignore.Pattern, error) {
+// LoadGitignoreFromFile reads the patterns in a gitignore style file.
+// domain is the path of the directory holding the file, which the
+// patterns are relative to.
+func LoadGitignoreFromFile(path string, domain []string) ([]gitignore.Pattern, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("read gitignore file: %w", err)
@@ -147,7 +433,7 @@ func LoadGitignoreFromFile(path string) ([]gitignore.Pattern, error) {
 	for _, line := range strings.Split(string(content), "\n") {
 		line = strings.TrimSpace(line)
 		if line != "" && !strings.HasPrefix(line, "#") {
-			patterns = append(patterns, gitignore.ParsePattern(line, nil))
+			patterns = append(patterns, gitignore.ParsePattern(line, domain))
 		}
 	}
 
@@ -174,22 +460,17 @@ func isTextFile(filePath string) bool {
 	if err != nil {
 		return false
 	}
+	return isTextContent(data)
+}
+
+// isTextContent checks if the data is text in an encoding that can be
+// converted to UTF-8 rather than binary
+func isTextContent(data []byte) bool {
 	if len(data) == 0 {
 		return true
 	}
 
-	// Check only first 512 bytes for performance
-	if len(data) > 512 {
-		data = data[:512]
-	}
-
-	// Only check for null bytes which typically indicate binary files
-	for _, b := range data {
-		if b == 0 {
-			return false
-		}
-	}
-	return true
+	return detectEncoding(data) != nil
 }
 
 func extractTitle(htmlContent string) string {
@@ -220,36 +501,68 @@ func extractTitleFromNode(n *html.Node) string {
 	return ""
 }
 
-// AddDocument adds a single document to the database
+// AddDocument adds a single document to the database. Local paths are
+// relative to the current directory.
 func AddDocument(ctx context.Context, db *sql.DB, path string) error {
-	doc, err := FetchDocument(path)
+	progress := progressFrom(ctx)
+	path = StoredPath(path)
+
+	previous := GetDocumentByPath(db, path)
+	doc, err := FetchDocumentIfModified(path, previous)
+	if errors.Is(err, ErrNotModified) {
+		progress.Skipped()
+		progress.Printf("Document already exists and not modified: %s\n", path)
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("fetch document %s: %w", path, err)
 	}
+	progress.Fetched()
+	keepSourceMetadata(doc, previous)
+
+	return storeDocument(ctx, db, doc)
+}
+
+// storeDocument embeds and saves a fetched document unless it is
+// empty or has not changed since it was last added
+func storeDocument(ctx context.Context, db *sql.DB, doc *Document) error {
+	progress := progressFrom(ctx)
 
 	existingDoc := GetDocumentByPath(db, doc.Path)
-	if existingDoc != nil && existingDoc.Content == doc.Content {
-		fmt.Printf("Document already exists and not modified: %s\n", doc.Path)
+	if existingDoc != nil && existingDoc.Content == doc.Content && existingDoc.Title == doc.Title {
+		// Keep validators like ETag current even if the content is same
+		if !maps.Equal(existingDoc.Metadata, doc.Metadata) {
+			err := writerFor(db).write(func(tx *sql.Tx) error {
+				return saveDocumentMetadata(tx, doc.Path, doc.Metadata)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		progress.Skipped()
+		progress.Printf("Document already exists and not modified: %s\n", doc.Path)
 		return nil
 	}
 
 	if doc.Content == "" {
-		fmt.Printf("Document is empty: %s\n", doc.Path)
+		progress.Skipped()
+		progress.Printf("Document is empty: %s\n", doc.Path)
 		return nil
 	}
 
-	// Generate and serialize embedding
-	embedding, err := CreateAndSerializeEmbedding(ctx, doc.Content)
+	chunks, err := EmbedDocument(ctx, doc)
 	if err != nil {
 		return err
 	}
 
 	// Update database
-	if err := UpdateDocument(db, doc, embedding); err != nil {
+	if err := UpdateDocument(db, doc, chunks); err != nil {
 		return err
 	}
 
-	fmt.Printf("Added document: %s\n", doc.Path)
+	progress.Embedded()
+	progress.Printf("Added document: %s\n", doc.Path)
 	return nil
 }
 
@@ -267,21 +580,192 @@ func CreateAndSerializeEmbedding(ctx context.Context, content string) ([]byte, e
 	return serialized, nil
 }
 
-func UpdateDocument(db *sql.DB, doc *Document, embedding []byte) error {
-	// Delete existing document if it exists
-	_, err := db.Exec("DELETE FROM documents WHERE filepath = ?", doc.Path)
-	if err != nil {
-		return fmt.Errorf("delete existing document: %w", err)
+// EmbedDocument splits a document into chunks and creates their
+// embeddings
+func EmbedDocument(ctx context.Context, doc *Document) ([]Chunk, error) {
+	var chunks []Chunk
+	for _, text := range embeddingChunks(doc) {
+		embedding, err := CreateAndSerializeEmbedding(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = append(chunks, Chunk{Content: text, Embedding: embedding})
 	}
 
-	// Insert new document
-	_, err = db.Exec(
-		"INSERT INTO documents(filepath, content, title, embedding) VALUES (?, ?, ?, ?)",
-		doc.Path, doc.Content, doc.Title, embedding)
-	if err != nil {
-		return fmt.Errorf("insert document: %w", err)
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("no text to embed in %s", doc.Path)
+	}
+
+	return chunks, nil
+}
+
+// embeddingChunks splits the embedding text of a document into chunks.
+// Markdown is split per section and every chunk of a section starts
+// with its heading path, so that chunks never span two sections and
+// keep the context of the headings.
+func embeddingChunks(doc *Document) []string {
+	if !isMarkdownDocument(doc) {
+		return splitChunks(EmbeddingText(doc), maxChunkLength)
+	}
+
+	sections := markdownSections(doc.Content)
+	if len(sections) == 0 {
+		return splitChunks(doc.Content, maxChunkLength)
+	}
+
+	var chunks []string
+	for _, section := range sections {
+		if section.path == "" {
+			chunks = append(chunks, splitChunks(section.text, maxChunkLength)...)
+			continue
+		}
+
+		// Leave room for the heading path, which could only take up
+		// most of the chunk with absurdly long headings
+		limit := max(maxChunkLength-len(section.path)-1, maxChunkLength/2)
+		for _, text := range splitChunks(section.text, limit) {
+			chunks = append(chunks, markdownSection{path: section.path, text: text}.String())
+		}
+	}
+
+	return chunks
+}
+
+// splitChunks splits text into chunks of at most limit bytes. Chunks end
+// at paragraphs where possible, then at lines and words.
+func splitChunks(text string, limit int) []string {
+	return splitText(strings.TrimSpace(text), []string{"\n\n", "\n", " "}, limit)
+}
+
+// splitText splits text at the first separator, joining the parts back
+// up to limit. Parts that are too long on their own are split at the
+// next separator, or between characters after the last one.
+func splitText(text string, separators []string, limit int) []string {
+	if len(text) <= limit {
+		if text == "" {
+			return nil
+		}
+		return []string{text}
 	}
 
+	if len(separators) == 0 {
+		var chunks []string
+		for len(text) > limit {
+			cut := limit
+			for cut > 0 && !utf8.RuneStart(text[cut]) {
+				cut--
+			}
+			chunks = append(chunks, text[:cut])
+			text = text[cut:]
+		}
+		return append(chunks, text)
+	}
+
+	sep := separators[0]
+
+	var (
+		chunks  []string
+		current string
+	)
+	flush := func() {
+		if current = strings.TrimSpace(current); current != "" {
+			chunks = append(chunks, current)
+		}
+		current = ""
+	}
+
+	for _, part := range strings.Split(text, sep) {
+		if len(part) > limit {
+			flush()
+			chunks = append(chunks, splitText(strings.TrimSpace(part), separators[1:], limit)...)
+			continue
+		}
+
+		if current != "" && len(current)+len(sep)+len(part) > limit {
+			flush()
+		}
+
+		if current == "" {
+			current = part
+		} else {
+			current += sep + part
+		}
+	}
+	flush()
+
+	return chunks
+}
+
+// UpdateDocument adds a document with its chunks or replaces the
+// existing version of it. The document keeps its ID when it is
+// replaced. The replace is atomic, either all of it is written or the
+// previous version is kept.
+func UpdateDocument(db *sql.DB, doc *Document, chunks []Chunk) error {
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		return replaceDocument(tx, doc, chunks)
+	})
+}
+
+func replaceDocument(tx *sql.Tx, doc *Document, chunks []Chunk) error {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM documents WHERE filepath = ?", doc.Path).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		result, err := tx.Exec(
+			"INSERT INTO documents (filepath, content, title) VALUES (?, ?, ?)",
+			doc.Path, doc.Content, doc.Title)
+		if err != nil {
+			return fmt.Errorf("insert document: %w", err)
+		}
+
+		id, err = result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("insert document: %w", err)
+		}
+	case err != nil:
+		return fmt.Errorf("query existing document: %w", err)
+	default:
+		if _, err := tx.Exec(
+			"UPDATE documents SET content = ?, title = ? WHERE id = ?",
+			doc.Content, doc.Title, id); err != nil {
+			return fmt.Errorf("update document: %w", err)
+		}
+
+		if err := deleteDocumentChunks(tx, id); err != nil {
+			return err
+		}
+	}
+
+	for i, chunk := range chunks {
+		result, err := tx.Exec(
+			"INSERT INTO chunks (document_id, seq, content) VALUES (?, ?, ?)",
+			id, i, chunk.Content)
+		if err != nil {
+			return fmt.Errorf("insert chunk: %w", err)
+		}
+
+		chunkID, err := result.LastInsertId()
+		if err != nil {
+			return fmt.Errorf("insert chunk: %w", err)
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO chunk_embeddings (rowid, embedding) VALUES (?, ?)",
+			chunkID, chunk.Embedding); err != nil {
+			return fmt.Errorf("insert chunk embedding: %w", err)
+		}
+	}
+
+	if err := saveDocumentMetadata(tx, doc.Path, doc.Metadata); err != nil {
+		return err
+	}
+
+	if err := saveDocumentLinks(tx, doc.Path, doc.Links); err != nil {
+		return err
+	}
+
+	doc.ID = id
 	return nil
 }
 
@@ -291,6 +775,9 @@ func AddDocuments(ctx context.Context, db *sql.DB, paths []string, maxWorkers in
 		maxWorkers = maxParallelEmbeddingRequests
 	}
 
+	progress := progressFrom(ctx)
+	progress.Discovered(len(paths))
+
 	// Create buffered channels for paths and errors
 	pathChan := make(chan string, len(paths))
 	errChan := make(chan error, len(paths))
@@ -302,7 +789,15 @@ func AddDocuments(ctx context.Context, db *sql.DB, paths []string, maxWorkers in
 		go func() {
 			defer wg.Done()
 			for path := range pathChan {
-				if err := AddDocument(ctx, db, path); err != nil {
+				// Stop picking up documents once cancelled. Documents
+				// that were started are finished so that the work done
+				// for them is saved.
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := AddDocument(context.WithoutCancel(ctx), db, path); err != nil {
+					progress.Failed()
 					errChan <- fmt.Errorf("%s: %w", path, err)
 				} else {
 					errChan <- nil
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitChunks(t *testing.T) {
+	paragraph := strings.Repeat("word ", 300)   // 1500 bytes
+	line := strings.Repeat("a", 1500)           // a line without spaces
+	accented := strings.Repeat("é", 1500)       // 3000 bytes of 2 byte characters
+	mixed := strings.Repeat("x", 1999) + "€abc" // a 3 byte character across the limit
+
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"empty", "  \n ", nil},
+		{"short", " short text \n", []string{"short text"}},
+		{
+			name: "paragraphs are joined up to the limit",
+			text: "one\n\ntwo\n\n" + paragraph,
+			want: []string{"one\n\ntwo\n\n" + strings.TrimSpace(paragraph)},
+		},
+		{
+			name: "paragraphs over the limit",
+			text: paragraph + "\n\n" + paragraph,
+			want: []string{strings.TrimSpace(paragraph), strings.TrimSpace(paragraph)},
+		},
+		{
+			name: "falls back to lines",
+			text: line + "\n" + line,
+			want: []string{line, line},
+		},
+		{
+			name: "falls back to words",
+			text: strings.Repeat("word ", 500),
+			want: []string{strings.TrimSpace(strings.Repeat("word ", 400)), strings.TrimSpace(strings.Repeat("word ", 100))},
+		},
+		{
+			name: "cuts between characters",
+			text: accented,
+			want: []string{strings.Repeat("é", 1000), strings.Repeat("é", 500)},
+		},
+		{
+			name: "does not cut within a character",
+			text: mixed,
+			want: []string{strings.Repeat("x", 1999), "€abc"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitChunks(tt.text, maxChunkLength)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitChunks() returned %d chunks, want %d", len(got), len(tt.want))
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("chunk %d = %q, want %q", i, got[i], tt.want[i])
+				}
+				if len(got[i]) > maxChunkLength || !utf8.ValidString(got[i]) {
+					t.Errorf("chunk %d is %d bytes or not valid UTF-8", i, len(got[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddingChunks(t *testing.T) {
+	paragraph := strings.Repeat("Install the package with the package manager. ", 20)
+
+	doc := &Document{
+		Path: "guide.md",
+		Content: "---\ntitle: Guide\n---\nIntro text\n\n" +
+			"# Guide\n\n## Install\n\n### Linux\n\n" +
+			paragraph + "\n\n" + paragraph + "\n\n" + paragraph + "\n\n" +
+			"### macOS\n\nUse brew.\n\n" +
+			"```sh\n# not a heading\n```\n",
+	}
+
+	chunks := embeddingChunks(doc)
+
+	want := []string{
+		"Intro text",
+		"Guide > Install > Linux\n" + strings.TrimSpace(paragraph+"\n\n"+paragraph),
+		"Guide > Install > Linux\n" + strings.TrimSpace(paragraph),
+		"Guide > Install > macOS\nUse brew.\n\n```sh\n# not a heading\n```",
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("embeddingChunks() = %q, want %q", chunks, want)
+	}
+	for i := range chunks {
+		if chunks[i] != want[i] {
+			t.Errorf("chunk %d = %q, want %q", i, chunks[i], want[i])
+		}
+		if len(chunks[i]) > maxChunkLength {
+			t.Errorf("chunk %d is %d bytes", i, len(chunks[i]))
+		}
+	}
+}
+
+func TestEmbeddingChunksPlainText(t *testing.T) {
+	doc := &Document{Path: "notes.txt", Content: "# not markdown\n\ntext"}
+
+	chunks := embeddingChunks(doc)
+	if len(chunks) != 1 || chunks[0] != "# not markdown\n\ntext" {
+		t.Errorf("embeddingChunks() = %q, want the content as is", chunks)
+	}
+}
@@ -0,0 +1,449 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+// mboxSeparator separates the mbox file path from the message id in
+// the path of a document created from an mbox message
+const mboxSeparator = "#"
+
+var headerLinePattern = regexp.MustCompile(`^[A-Za-z0-9-]+:`)
+
+// mboxSpan is the location of a single message within an mbox file
+type mboxSpan struct {
+	start int64
+	end   int64
+}
+
+// mboxIndex maps message ids to their location in an mbox file
+type mboxIndex struct {
+	modTime time.Time
+	size    int64
+	keys    []string
+	spans   map[string]mboxSpan
+}
+
+// mboxIndexes caches the index of each mbox file so that fetching
+// every message does not have to scan the whole file
+var mboxIndexes sync.Map
+
+// isMboxFile checks if the file is in mbox format. An mbox file starts
+// with a "From " line followed by message headers.
+func isMboxFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	first, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(first, "From ") {
+		return false
+	}
+
+	second, err := reader.ReadString('\n')
+	if err != nil && second == "" {
+		return false
+	}
+
+	return headerLinePattern.MatchString(second)
+}
+
+// isMaildirMessage checks if the file is a message in a Maildir. These
+// are files in the cur or new directory of a folder which contains cur,
+// new and tmp directories.
+func isMaildirMessage(path string) bool {
+	dir := filepath.Dir(path)
+	if base := filepath.Base(dir); base != "cur" && base != "new" {
+		return false
+	}
+
+	return isMaildir(filepath.Dir(dir))
+}
+
+// isMaildir checks if the directory is a Maildir
+func isMaildir(dir string) bool {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		info, err := os.Stat(filepath.Join(dir, sub))
+		if err != nil || !info.IsDir() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isMaildirTemporary checks if the file is in the tmp directory of a
+// Maildir. These are messages that are still being delivered.
+func isMaildirTemporary(path string) bool {
+	dir := filepath.Dir(path)
+	return filepath.Base(dir) == "tmp" && isMaildir(filepath.Dir(dir))
+}
+
+// isMailPath checks if the path refers to an email message
+func isMailPath(path string) bool {
+	if _, _, ok := splitMboxPath(path); ok {
+		return true
+	}
+
+	return isMaildirMessage(path)
+}
+
+// splitMboxPath splits a path of the form <mbox>#<message-id> into its
+// parts. It only succeeds if the first part is an mbox file.
+func splitMboxPath(path string) (string, string, bool) {
+	offset := 0
+	for {
+		i := strings.Index(path[offset:], mboxSeparator)
+		if i == -1 {
+			return "", "", false
+		}
+
+		file, key := path[:offset+i], path[offset+i+len(mboxSeparator):]
+		if key != "" && fileExists(file) && isMboxFile(file) {
+			return file, key, true
+		}
+
+		offset += i + len(mboxSeparator)
+	}
+}
+
+// expandMbox returns the paths of every message in an mbox file
+func expandMbox(path string) ([]string, error) {
+	index, err := loadMboxIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(index.keys))
+	for _, key := range index.keys {
+		paths = append(paths, path+mboxSeparator+key)
+	}
+
+	return paths, nil
+}
+
+// loadMboxIndex returns the index for the mbox file, building it if it
+// is missing or if the file has changed
+func loadMboxIndex(path string) (*mboxIndex, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat mbox %s: %w", path, err)
+	}
+
+	if cached, ok := mboxIndexes.Load(path); ok {
+		index := cached.(*mboxIndex)
+		if index.modTime.Equal(info.ModTime()) && index.size == info.Size() {
+			return index, nil
+		}
+	}
+
+	index, err := buildMboxIndex(path)
+	if err != nil {
+		return nil, err
+	}
+
+	index.modTime = info.ModTime()
+	index.size = info.Size()
+	mboxIndexes.Store(path, index)
+
+	return index, nil
+}
+
+func buildMboxIndex(path string) (*mboxIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open mbox %s: %w", path, err)
+	}
+	defer f.Close()
+
+	index := &mboxIndex{spans: map[string]mboxSpan{}}
+
+	var (
+		offset    int64
+		start     int64 = -1
+		messageID string
+		inHeaders bool
+		prevBlank = true
+		count     int
+	)
+
+	finish := func(end int64) {
+		if start == -1 {
+			return
+		}
+
+		count++
+		key := messageID
+		if key == "" {
+			key = strconv.Itoa(count)
+		}
+
+		// Duplicate messages are common in mbox exports, only keep the first
+		if _, ok := index.spans[key]; !ok {
+			index.keys = append(index.keys, key)
+			index.spans[key] = mboxSpan{start: start, end: end}
+		}
+	}
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			trimmed := strings.TrimRight(line, "\r\n")
+
+			switch {
+			case prevBlank && strings.HasPrefix(line, "From "):
+				finish(offset)
+				start = offset + int64(len(line))
+				messageID = ""
+				inHeaders = true
+			case inHeaders && trimmed == "":
+				inHeaders = false
+			case inHeaders && messageID == "":
+				if name, value, ok := strings.Cut(trimmed, ":"); ok &&
+					strings.EqualFold(name, "Message-ID") {
+					messageID = strings.Trim(strings.TrimSpace(value), "<>")
+				}
+			}
+
+			prevBlank = trimmed == ""
+			offset += int64(len(line))
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read mbox %s: %w", path, err)
+		}
+	}
+	finish(offset)
+
+	return index, nil
+}
+
+// fetchMboxMessage reads a single message out of an mbox file
+func fetchMboxMessage(path, file, key string) (*Document, error) {
+	index, err := loadMboxIndex(file)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ok := index.spans[key]
+	if !ok {
+		return nil, fmt.Errorf("message %s in %s: %w", key, file, fs.ErrNotExist)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open mbox %s: %w", file, err)
+	}
+	defer f.Close()
+
+	raw := make([]byte, span.end-span.start)
+	if _, err := f.ReadAt(raw, span.start); err != nil {
+		return nil, fmt.Errorf("read message %s: %w", key, err)
+	}
+
+	// Undo the ">From " quoting used within mbox messages
+	var unquoted bytes.Buffer
+	for _, line := range bytes.SplitAfter(raw, []byte("\n")) {
+		trimmed := bytes.TrimLeft(line, ">")
+		if len(trimmed) < len(line) && bytes.HasPrefix(trimmed, []byte("From ")) {
+			line = line[1:]
+		}
+		unquoted.Write(line)
+	}
+
+	return parseEmail(path, unquoted.Bytes())
+}
+
+// fetchMaildirMessage reads a single message from a Maildir
+func fetchMaildirMessage(path string) (*Document, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file %s: %w", path, err)
+	}
+
+	return parseEmail(path, raw)
+}
+
+// parseEmail converts a raw RFC 5322 message into a document
+func parseEmail(path string, raw []byte) (*Document, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("parse message %s: %w", path, err)
+	}
+
+	decoder := &mime.WordDecoder{CharsetReader: charsetReader}
+	decodeHeader := func(name string) string {
+		value := msg.Header.Get(name)
+		if decoded, err := decoder.DecodeHeader(value); err == nil {
+			value = decoded
+		}
+		return strings.TrimSpace(value)
+	}
+
+	doc := &Document{
+		Path:     path,
+		Title:    decodeHeader("Subject"),
+		IsRemote: false,
+		Metadata: map[string]string{},
+	}
+
+	if doc.Title == "" {
+		doc.Title = "(no subject)"
+	}
+
+	for _, name := range []string{"From", "To", "Cc"} {
+		if value := decodeHeader(name); value != "" {
+			doc.Metadata[strings.ToLower(name)] = value
+		}
+	}
+
+	if date, err := msg.Header.Date(); err == nil {
+		doc.Metadata["date"] = date.Format(time.RFC3339)
+	} else if value := decodeHeader("Date"); value != "" {
+		doc.Metadata["date"] = value
+	}
+
+	if id := strings.Trim(decodeHeader("Message-ID"), "<>"); id != "" {
+		doc.Metadata["message_id"] = id
+	}
+
+	content, err := emailText(
+		msg.Header.Get("Content-Type"),
+		msg.Header.Get("Content-Transfer-Encoding"),
+		msg.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read message body %s: %w", path, err)
+	}
+
+	doc.Content = strings.TrimSpace(content)
+
+	return doc, nil
+}
+
+// emailText extracts the text content of a MIME part. For
+// multipart/alternative, plain text is preferred over HTML. HTML parts
+// are converted to markdown.
+func emailText(contentType, transferEncoding string, body io.Reader) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	body = decodeTransferEncoding(transferEncoding, body)
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+
+		var parts []string
+		var plain, html string
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+
+			partType := part.Header.Get("Content-Type")
+			if partType == "" {
+				partType = "text/plain"
+			}
+
+			// Skip attachments
+			if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" {
+				continue
+			}
+
+			text, err := emailText(partType, part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			if text == "" {
+				continue
+			}
+
+			if mediaType == "multipart/alternative" {
+				switch {
+				case strings.HasPrefix(partType, "text/plain") && plain == "":
+					plain = text
+				case html == "":
+					html = text
+				}
+				continue
+			}
+
+			parts = append(parts, text)
+		}
+
+		if mediaType == "multipart/alternative" {
+			if plain != "" {
+				return plain, nil
+			}
+			return html, nil
+		}
+
+		return strings.Join(parts, "\n\n"), nil
+	}
+
+	if !strings.HasPrefix(mediaType, "text/") {
+		return "", nil
+	}
+
+	raw, err := io.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := decodeTextCharset(raw, params["charset"])
+	if err != nil {
+		content = normalizeText(string(raw))
+	}
+
+	if mediaType == "text/html" {
+		converter := md.NewConverter("", true, nil)
+		converted, err := converter.ConvertString(content)
+		if err != nil {
+			return "", fmt.Errorf("convert HTML to markdown: %w", err)
+		}
+		return strings.TrimSpace(converted), nil
+	}
+
+	return strings.TrimSpace(content), nil
+}
+
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	default:
+		return body
+	}
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/encoding/unicode/utf32"
+	"golang.org/x/text/unicode/norm"
+)
+
+// byteOrderMarks maps BOMs to their encoding. UTF-32 comes first as the
+// UTF-32LE BOM starts with the UTF-16LE one.
+var byteOrderMarks = []struct {
+	bom      []byte
+	encoding encoding.Encoding
+}{
+	{[]byte{0x00, 0x00, 0xFE, 0xFF}, utf32.UTF32(utf32.BigEndian, utf32.ExpectBOM)},
+	{[]byte{0xFF, 0xFE, 0x00, 0x00}, utf32.UTF32(utf32.LittleEndian, utf32.ExpectBOM)},
+	{[]byte{0xEF, 0xBB, 0xBF}, unicode.UTF8BOM},
+	{[]byte{0xFE, 0xFF}, unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)},
+	{[]byte{0xFF, 0xFE}, unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)},
+}
+
+// detectEncoding guesses the encoding of the data from its BOM or its
+// contents. UTF-8 is assumed if most of the non-ASCII characters are
+// valid UTF-8, with invalid bytes decoded as U+FFFD. Otherwise
+// Windows-1252 which is a superset of Latin-1 is used. nil is returned
+// if the data looks binary.
+func detectEncoding(data []byte) encoding.Encoding {
+	for _, b := range byteOrderMarks {
+		if bytes.HasPrefix(data, b.bom) {
+			return b.encoding
+		}
+	}
+
+	// Check only the first 512 bytes for performance
+	sample := data
+	if len(sample) > 512 {
+		sample = sample[:512]
+	}
+
+	if enc := detectUTF16(sample); enc != nil {
+		return enc
+	}
+
+	// Null bytes in anything other than UTF-16 indicate binary files
+	if bytes.IndexByte(sample, 0) != -1 {
+		return nil
+	}
+
+	invalid, multiByte := countUTF8(data)
+	switch {
+	case invalid == 0:
+		return encoding.Nop
+	case invalid < multiByte:
+		// A few stray bytes in UTF-8 text should not turn every
+		// multi-byte character into mojibake
+		return unicode.UTF8
+	}
+
+	return charmap.Windows1252
+}
+
+// detectUTF16 detects UTF-16 without a BOM from the null bytes that
+// ASCII characters have in either the even or odd positions
+func detectUTF16(sample []byte) encoding.Encoding {
+	if len(sample) < 4 {
+		return nil
+	}
+
+	var evenNulls, oddNulls int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenNulls++
+		} else {
+			oddNulls++
+		}
+	}
+
+	// More than 40% of one and less than 10% of the other position,
+	// compared without dividing so that short samples are detected too
+	pairs := len(sample) / 2
+	switch {
+	case oddNulls*5 > pairs*2 && evenNulls*10 < pairs:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM)
+	case evenNulls*5 > pairs*2 && oddNulls*10 < pairs:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM)
+	}
+
+	return nil
+}
+
+// countUTF8 returns the number of bytes that are not valid UTF-8 and the
+// number of valid multi-byte characters in the data
+func countUTF8(data []byte) (int, int) {
+	invalid, multiByte := 0, 0
+	for i := 0; i < len(data); {
+		if data[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			invalid++
+		} else {
+			multiByte++
+		}
+		i += size
+	}
+
+	return invalid, multiByte
+}
+
+// decodeText converts the data to UTF-8 using the detected encoding and
+// normalizes it. false is returned if the data is not text.
+func decodeText(data []byte) (string, bool) {
+	enc := detectEncoding(data)
+	if enc == nil {
+		return "", false
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", false
+	}
+
+	return normalizeText(string(decoded)), true
+}
+
+// decodeTextCharset converts the data to UTF-8 using the given charset
+// label (eg: from a Content-Type header), falling back to detecting the
+// encoding if the label is empty or unknown
+func decodeTextCharset(data []byte, charset string) (string, error) {
+	if charset != "" {
+		if enc, err := htmlindex.Get(charset); err == nil {
+			decoded, err := enc.NewDecoder().Bytes(data)
+			if err != nil {
+				return "", fmt.Errorf("decode %s: %w", charset, err)
+			}
+			return normalizeText(string(decoded)), nil
+		}
+	}
+
+	text, ok := decodeText(data)
+	if !ok {
+		return "", fmt.Errorf("not a text document")
+	}
+
+	return text, nil
+}
+
+// charsetReader returns a reader that converts from the given charset to
+// UTF-8, used for email headers and XML documents in other encodings
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", charset, err)
+	}
+
+	return enc.NewDecoder().Reader(input), nil
+}
+
+// normalizeText converts line endings to \n and composes the text to
+// Unicode NFC so that the same text always has the same bytes
+func normalizeText(text string) string {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return norm.NFC.String(text)
+}
@@ -0,0 +1,47 @@
+package internal
+
+import "testing"
+
+func TestDecodeText(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		want   string
+		binary bool
+	}{
+		{name: "ascii", data: "hello", want: "hello"},
+		{name: "utf-8", data: "café", want: "café"},
+		{name: "utf-8 bom", data: "\xEF\xBB\xBFcafé", want: "café"},
+		{name: "utf-16le bom", data: "\xFF\xFEh\x00i\x00", want: "hi"},
+		{name: "utf-16be bom", data: "\xFE\xFF\x00h\x00i", want: "hi"},
+		{name: "utf-16le", data: "h\x00e\x00l\x00l\x00o\x00", want: "hello"},
+		{name: "utf-16be", data: "\x00h\x00e\x00l\x00l\x00o", want: "hello"},
+		{name: "utf-32le bom", data: "\xFF\xFE\x00\x00h\x00\x00\x00i\x00\x00\x00", want: "hi"},
+		{name: "utf-32be bom", data: "\x00\x00\xFE\xFF\x00\x00\x00h\x00\x00\x00i", want: "hi"},
+		{name: "latin-1", data: "caf\xE9", want: "café"},
+		{name: "windows-1252", data: "\x93quoted\x94", want: "“quoted”"},
+		{name: "utf-8 with a stray byte", data: "café naïve résumé \xFF", want: "café naïve résumé �"},
+		{name: "crlf", data: "a\r\nb\rc", want: "a\nb\nc"},
+		{name: "nfc", data: "é", want: "é"},
+		{name: "binary", data: "abc\x00def", binary: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := decodeText([]byte(tt.data))
+			if tt.binary {
+				if ok {
+					t.Fatalf("decodeText(%q) = %q, want it to be detected as binary", tt.data, got)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("decodeText(%q) detected binary data", tt.data)
+			}
+
+			if got != tt.want {
+				t.Errorf("decodeText(%q) = %q, want %q", tt.data, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,327 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// exportVersion is the version of the export format. It is increased on
+// changes that older versions of refer cannot import.
+const exportVersion = 1
+
+// importBatchSize is the number of documents written in a single
+// transaction while importing
+const importBatchSize = 256
+
+// Record types in an export
+const (
+	exportTypeConfig   = "config"
+	exportTypeDocument = "document"
+)
+
+// exportRecord is a single line of an export. The first line is a config
+// record holding the config table, followed by a document record for
+// every document.
+type exportRecord struct {
+	Type string `json:"type"`
+
+	// Config records
+	Version int               `json:"version,omitempty"`
+	Config  map[string]string `json:"config,omitempty"`
+
+	// Document records
+	Path     string            `json:"path,omitempty"`
+	Title    string            `json:"title,omitempty"`
+	Content  string            `json:"content,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Links    []exportLink      `json:"links,omitempty"`
+	Chunks   []exportChunk     `json:"chunks,omitempty"`
+}
+
+type exportLink struct {
+	Target string `json:"target"`
+	Kind   string `json:"kind"`
+}
+
+// exportChunk is a chunk with its embedding encoded as base64 of the
+// little-endian float32 values
+type exportChunk struct {
+	Content   string `json:"content"`
+	Embedding string `json:"embedding"`
+}
+
+// Export writes the config and all documents with their metadata, links,
+// chunks and embeddings as JSON lines. It returns the number of
+// documents written.
+func Export(db *sql.DB, w io.Writer) (int, error) {
+	config, err := GetConfig(db)
+	if err != nil {
+		return 0, err
+	}
+
+	// The schema version and index root belong to the database being
+	// imported into
+	delete(config, schemaVersionKey)
+	delete(config, indexRootKey)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	err = encoder.Encode(exportRecord{Type: exportTypeConfig, Version: exportVersion, Config: config})
+	if err != nil {
+		return 0, fmt.Errorf("write config: %w", err)
+	}
+
+	docs, err := GetAllDocuments(db)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, doc := range docs {
+		record := exportRecord{
+			Type:    exportTypeDocument,
+			Path:    doc.Path,
+			Title:   doc.Title,
+			Content: doc.Content,
+		}
+
+		record.Metadata, err = GetDocumentMetadata(db, doc.Path)
+		if err != nil {
+			return i, err
+		}
+
+		links, err := getDocumentOutgoingLinks(db, doc.Path)
+		if err != nil {
+			return i, err
+		}
+		for _, link := range links {
+			record.Links = append(record.Links, exportLink{Target: link.Target, Kind: link.Kind})
+		}
+
+		chunks, err := GetDocumentChunks(db, doc.ID)
+		if err != nil {
+			return i, err
+		}
+		for _, chunk := range chunks {
+			record.Chunks = append(record.Chunks, exportChunk{
+				Content:   chunk.Content,
+				Embedding: base64.StdEncoding.EncodeToString(chunk.Embedding),
+			})
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return i, fmt.Errorf("write document %s: %w", doc.Path, err)
+		}
+	}
+
+	return len(docs), nil
+}
+
+// ImportOptions configures an import
+type ImportOptions struct {
+	// AllowExec imports documents created from the output of a command.
+	// Their commands are run on reindex, so they are skipped by default.
+	AllowExec bool
+}
+
+// Import loads documents written by Export into the database, replacing
+// documents with the same path. The export must have been created with
+// the embedding model that is configured and, if the database already
+// has documents, with the same embedding size. An empty database is
+// initialized from the config in the export. It returns the number of
+// documents imported and skipped.
+func Import(db *sql.DB, r io.Reader, opts ImportOptions) (int, int, error) {
+	decoder := json.NewDecoder(r)
+
+	var header exportRecord
+	if err := decoder.Decode(&header); err != nil {
+		return 0, 0, fmt.Errorf("read config: %w", err)
+	}
+	if header.Type != exportTypeConfig {
+		return 0, 0, fmt.Errorf("not a refer export, expected a config record first")
+	}
+	if header.Version > exportVersion {
+		return 0, 0, fmt.Errorf("export version %d is newer than %d, please upgrade refer", header.Version, exportVersion)
+	}
+
+	embeddingSize, err := validateImportConfig(db, header.Config)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	type importedDocument struct {
+		doc    *Document
+		chunks []Chunk
+	}
+
+	count, skipped := 0, 0
+	var batch []importedDocument
+	flush := func() error {
+		err := writerFor(db).write(func(tx *sql.Tx) error {
+			for _, imported := range batch {
+				if err := replaceDocument(tx, imported.doc, imported.chunks); err != nil {
+					return fmt.Errorf("import %s: %w", imported.doc.Path, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var record exportRecord
+		err := decoder.Decode(&record)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return count, skipped, fmt.Errorf("read document %d: %w", count+len(batch)+skipped+1, err)
+		}
+
+		if record.Type != exportTypeDocument {
+			return count, skipped, fmt.Errorf("unexpected %q record", record.Type)
+		}
+
+		if record.Metadata[metadataCommand] != "" && !opts.AllowExec {
+			skipped++
+			continue
+		}
+
+		doc, chunks, err := importDocument(record, embeddingSize)
+		if err != nil {
+			return count, skipped, err
+		}
+
+		batch = append(batch, importedDocument{doc: doc, chunks: chunks})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return count, skipped, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return count, skipped, err
+	}
+
+	return count, skipped, nil
+}
+
+// validateImportConfig checks that the documents in an export can be
+// searched with the configured model and stored in the database, and
+// returns the embedding size
+func validateImportConfig(db *sql.DB, config map[string]string) (int, error) {
+	model := config["embedding_model"]
+	if model != Model {
+		return 0, fmt.Errorf(
+			"export was created with embedding model %q but %q is configured",
+			model, Model)
+	}
+
+	embeddingSize, err := strconv.Atoi(config["embedding_size"])
+	if err != nil || embeddingSize <= 0 {
+		return 0, fmt.Errorf("invalid embedding size %q in export", config["embedding_size"])
+	}
+
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return 0, err
+	}
+
+	// A database without a schema has just been created
+	if version == 0 {
+		if err := InitDatabase(db, embeddingSize); err != nil {
+			return 0, fmt.Errorf("initialize database: %w", err)
+		}
+	} else {
+		current, err := GetConfig(db)
+		if err != nil {
+			return 0, err
+		}
+
+		if current["embedding_model"] != model {
+			return 0, fmt.Errorf(
+				"database embedding model %q does not match the export (%q)",
+				current["embedding_model"], model)
+		}
+
+		if current["embedding_size"] != config["embedding_size"] {
+			return 0, fmt.Errorf(
+				"database embedding size %s does not match the export (%s)",
+				current["embedding_size"], config["embedding_size"])
+		}
+	}
+
+	imported := map[string]string{}
+	for key, value := range config {
+		if key != schemaVersionKey && key != indexRootKey {
+			imported[key] = value
+		}
+	}
+
+	// Relative paths in the export are resolved against the index root
+	// of the database they are imported into
+	if version == 0 && IndexRoot != "" {
+		imported[indexRootKey] = IndexRoot
+	}
+
+	if err := SaveConfig(db, imported); err != nil {
+		return 0, err
+	}
+
+	return embeddingSize, nil
+}
+
+// importDocument converts a document record back into a document and
+// its chunks
+func importDocument(record exportRecord, embeddingSize int) (*Document, []Chunk, error) {
+	if record.Path == "" {
+		return nil, nil, fmt.Errorf("document without a path")
+	}
+
+	doc := &Document{
+		Path:     record.Path,
+		Title:    record.Title,
+		Content:  record.Content,
+		Metadata: record.Metadata,
+		IsRemote: IsRemoteURL(record.Path),
+	}
+
+	for _, link := range record.Links {
+		doc.Links = append(doc.Links, Link{Target: link.Target, Kind: link.Kind})
+	}
+
+	if len(record.Chunks) == 0 {
+		return nil, nil, fmt.Errorf("document %s has no chunks", record.Path)
+	}
+
+	chunks := make([]Chunk, 0, len(record.Chunks))
+	for i, chunk := range record.Chunks {
+		embedding, err := base64.StdEncoding.DecodeString(chunk.Embedding)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode embedding %d of %s: %w", i, record.Path, err)
+		}
+
+		// Embeddings are float32 values
+		if len(embedding) != embeddingSize*4 {
+			return nil, nil, fmt.Errorf(
+				"embedding %d of %s has %d dimensions, expected %d",
+				i, record.Path, len(embedding)/4, embeddingSize)
+		}
+
+		chunks = append(chunks, Chunk{Content: chunk.Content, Embedding: embedding})
+	}
+
+	return doc, chunks, nil
+}
@@ -0,0 +1,591 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	md "github.com/JohannesKaufmann/html-to-markdown"
+)
+
+// Feed is a parsed RSS or Atom feed
+type Feed struct {
+	Title   string
+	Entries []FeedEntry
+}
+
+// FeedEntry is a single item in a feed
+type FeedEntry struct {
+	GUID      string
+	Title     string
+	Link      string
+	Published time.Time
+	// Content is the entry content converted to markdown
+	Content string
+}
+
+type rssFeed struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0 has items as siblings of the channel
+	Items []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	ID    string `xml:"id"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+}
+
+// feedDateLayouts are the date formats seen in RSS and Atom feeds
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02T15:04:05Z07:00",
+	time.DateOnly,
+}
+
+// parseFeed parses an RSS (0.9x, 1.0, 2.0) or Atom feed
+func parseFeed(body []byte) (*Feed, error) {
+	root, err := xmlRootName(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root {
+	case "rss", "RDF":
+		var raw rssFeed
+		if err := decodeXML(body, &raw); err != nil {
+			return nil, fmt.Errorf("parse RSS feed: %w", err)
+		}
+
+		feed := &Feed{Title: strings.TrimSpace(raw.Channel.Title)}
+		for _, item := range append(raw.Channel.Items, raw.Items...) {
+			content := item.Content
+			if content == "" {
+				content = item.Description
+			}
+
+			entry := FeedEntry{
+				GUID:      strings.TrimSpace(item.GUID),
+				Title:     strings.TrimSpace(item.Title),
+				Link:      strings.TrimSpace(item.Link),
+				Published: parseFeedDate(item.PubDate, item.Date),
+				Content:   feedContentToMarkdown(content),
+			}
+			if entry.GUID == "" {
+				entry.GUID = entry.Link
+			}
+
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		return feed, nil
+	case "feed":
+		var raw atomFeed
+		if err := decodeXML(body, &raw); err != nil {
+			return nil, fmt.Errorf("parse Atom feed: %w", err)
+		}
+
+		feed := &Feed{Title: strings.TrimSpace(raw.Title)}
+		for _, item := range raw.Entries {
+			content := item.Content
+			if content == "" {
+				content = item.Summary
+			}
+
+			entry := FeedEntry{
+				GUID:      strings.TrimSpace(item.ID),
+				Title:     strings.TrimSpace(item.Title),
+				Published: parseFeedDate(item.Published, item.Updated),
+				Content:   feedContentToMarkdown(content),
+			}
+
+			for _, link := range item.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					entry.Link = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+			if entry.GUID == "" {
+				entry.GUID = entry.Link
+			}
+
+			feed.Entries = append(feed.Entries, entry)
+		}
+
+		return feed, nil
+	default:
+		return nil, fmt.Errorf("not a feed: unexpected root element <%s>", root)
+	}
+}
+
+// decodeXML unmarshals XML supporting encodings other than UTF-8
+func decodeXML(body []byte, v any) error {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charsetReader
+	return decoder.Decode(v)
+}
+
+// xmlRootName returns the local name of the root element
+func xmlRootName(body []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.CharsetReader = charsetReader
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("parse XML: %w", err)
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// parseFeedDate parses the first of the values that is a valid date
+func parseFeedDate(values ...string) time.Time {
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		for _, layout := range feedDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t
+			}
+		}
+	}
+
+	return time.Time{}
+}
+
+// feedContentToMarkdown converts the HTML content of an entry to
+// markdown
+func feedContentToMarkdown(content string) string {
+	converter := md.NewConverter("", true, nil)
+	converted, err := converter.ConvertString(content)
+	if err != nil {
+		return strings.TrimSpace(content)
+	}
+
+	return strings.TrimSpace(converted)
+}
+
+// feedDocument creates a single document listing all the entries of a
+// feed
+func feedDocument(url string, feed *Feed) *Document {
+	var content strings.Builder
+	for _, entry := range feed.Entries {
+		content.WriteString("## " + entry.Title + "\n\n")
+		if entry.Link != "" {
+			content.WriteString(entry.Link + "\n")
+		}
+		if !entry.Published.IsZero() {
+			content.WriteString(entry.Published.Format(time.RFC3339) + "\n")
+		}
+		if entry.Content != "" {
+			content.WriteString("\n" + entry.Content + "\n")
+		}
+		content.WriteString("\n")
+	}
+
+	title := feed.Title
+	if title == "" {
+		title = url
+	}
+
+	return &Document{
+		Path:     url,
+		Content:  strings.TrimSpace(content.String()),
+		Title:    title,
+		IsRemote: true,
+	}
+}
+
+// feedEntryPrefix is the prefix used for the path of feed entries that
+// are indexed from the feed content. The path looks like
+// feed+<feed url>#<guid>.
+const feedEntryPrefix = "feed+"
+
+// feedCache holds feeds fetched during this run so that refetching
+// many entries of a feed only downloads it once
+var feedCache sync.Map
+
+// FeedSubscription is a feed registered with `refer feed add`
+type FeedSubscription struct {
+	URL   string
+	Title string
+	// Articles indexes the linked article instead of the feed content
+	Articles   bool
+	AddedAt    time.Time
+	LastSynced time.Time
+}
+
+// isFeedEntryPath checks if the path refers to a feed entry
+func isFeedEntryPath(path string) bool {
+	return strings.HasPrefix(path, feedEntryPrefix)
+}
+
+// feedEntryPath builds the document path for a feed entry
+func feedEntryPath(feedURL, guid string) string {
+	return feedEntryPrefix + feedURL + "#" + guid
+}
+
+// fetchFeed downloads and parses a feed
+func fetchFeed(ctx context.Context, url string) (*Feed, error) {
+	req, client, err := newRemoteRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch feed %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &httpStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read feed: %w", err)
+	}
+
+	feed, err := parseFeed(body)
+	if err != nil {
+		return nil, err
+	}
+
+	feedCache.Store(url, feed)
+	return feed, nil
+}
+
+// feedEntryDocument creates a document from the content of a feed entry
+func feedEntryDocument(feedURL string, feed *Feed, entry FeedEntry) *Document {
+	doc := &Document{
+		Path:     feedEntryPath(feedURL, entry.GUID),
+		Content:  entry.Content,
+		Title:    entry.Title,
+		IsRemote: true,
+	}
+
+	if doc.Title == "" {
+		doc.Title = entry.Link
+	}
+	if doc.Content == "" {
+		doc.Content = doc.Title
+	}
+
+	setFeedEntryMetadata(doc, feed, entry)
+	return doc
+}
+
+// feedMetadataKeys are set on documents added from feeds. Articles are
+// fetched from their link, so the keys are kept when they are fetched
+// again as they do not come from the page itself.
+var feedMetadataKeys = []string{"feed", "guid", "link", "published"}
+
+func setFeedEntryMetadata(doc *Document, feed *Feed, entry FeedEntry) {
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+
+	doc.Metadata["feed"] = feed.Title
+	doc.Metadata["guid"] = entry.GUID
+	if entry.Link != "" {
+		doc.Metadata["link"] = entry.Link
+	}
+	if !entry.Published.IsZero() {
+		doc.Metadata["published"] = entry.Published.Format(time.RFC3339)
+	}
+}
+
+// keepFeedMetadata carries the feed metadata of the previous version of
+// an article over to a newly fetched one. Values of feed entries, which
+// are fetched from the feed, are kept.
+func keepFeedMetadata(doc, previous *Document) {
+	if previous.Metadata["guid"] == "" {
+		return
+	}
+
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+
+	for _, key := range feedMetadataKeys {
+		if value := previous.Metadata[key]; value != "" && doc.Metadata[key] == "" {
+			doc.Metadata[key] = value
+		}
+	}
+}
+
+// errFeedEntryGone is returned for entries that are no longer part of
+// their feed
+var errFeedEntryGone = errors.New("entry is no longer in the feed")
+
+// fetchFeedEntry fetches a single feed entry. errFeedEntryGone is
+// returned for entries that are no longer part of the feed.
+func fetchFeedEntry(path string) (*Document, error) {
+	feedURL, guid, ok := strings.Cut(strings.TrimPrefix(path, feedEntryPrefix), "#")
+	if !ok {
+		return nil, fmt.Errorf("invalid feed entry path: %s", path)
+	}
+
+	var feed *Feed
+	if cached, ok := feedCache.Load(feedURL); ok {
+		feed = cached.(*Feed)
+	} else {
+		var err error
+		feed, err = fetchFeed(context.Background(), feedURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, entry := range feed.Entries {
+		if entry.GUID == guid {
+			return feedEntryDocument(feedURL, feed, entry), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s: %w", path, errFeedEntryGone)
+}
+
+// AddFeed registers a feed so that its entries are added on sync
+func AddFeed(ctx context.Context, db *sql.DB, url string, articles bool) (*FeedSubscription, error) {
+	feed, err := fetchFeed(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &FeedSubscription{
+		URL:      url,
+		Title:    feed.Title,
+		Articles: articles,
+		AddedAt:  time.Now().UTC(),
+	}
+
+	if err := saveFeed(db, sub); err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+func saveFeed(db *sql.DB, sub *FeedSubscription) error {
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		return insertFeed(tx, sub)
+	})
+}
+
+func insertFeed(tx *sql.Tx, sub *FeedSubscription) error {
+	var lastSynced string
+	if !sub.LastSynced.IsZero() {
+		lastSynced = sub.LastSynced.Format(time.RFC3339)
+	}
+
+	_, err := tx.Exec(
+		"INSERT OR REPLACE INTO feeds (url, title, articles, added_at, last_synced) VALUES (?, ?, ?, ?, ?)",
+		sub.URL, sub.Title, sub.Articles, sub.AddedAt.Format(time.RFC3339), lastSynced)
+	if err != nil {
+		return fmt.Errorf("save feed %s: %w", sub.URL, err)
+	}
+
+	return nil
+}
+
+// GetFeeds returns all the registered feeds
+func GetFeeds(db *sql.DB) ([]FeedSubscription, error) {
+	rows, err := db.Query("SELECT url, title, articles, added_at, last_synced FROM feeds")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feeds: %v", err)
+	}
+	defer rows.Close()
+
+	var feeds []FeedSubscription
+	for rows.Next() {
+		var sub FeedSubscription
+		var addedAt, lastSynced string
+		if err := rows.Scan(&sub.URL, &sub.Title, &sub.Articles, &addedAt, &lastSynced); err != nil {
+			return nil, fmt.Errorf("failed to scan feed: %v", err)
+		}
+
+		sub.AddedAt, _ = time.Parse(time.RFC3339, addedAt)
+		sub.LastSynced, _ = time.Parse(time.RFC3339, lastSynced)
+		feeds = append(feeds, sub)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating feeds: %v", err)
+	}
+
+	return feeds, nil
+}
+
+// SyncFeeds fetches all registered feeds and adds entries that have not
+// been seen before. Entries are deduplicated using their GUID.
+func SyncFeeds(ctx context.Context, db *sql.DB) []error {
+	feeds, err := GetFeeds(db)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errors []error
+	for _, sub := range feeds {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if errs := syncFeed(ctx, db, sub); len(errs) > 0 {
+			errors = append(errors, errs...)
+		}
+	}
+
+	return errors
+}
+
+func syncFeed(ctx context.Context, db *sql.DB, sub FeedSubscription) []error {
+	feed, err := fetchFeed(ctx, sub.URL)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errors []error
+	for _, entry := range feed.Entries {
+		// Entries are tracked individually, the ones that are not added
+		// yet are picked up on the next sync
+		if ctx.Err() != nil {
+			break
+		}
+
+		var exists bool
+		err := db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM feed_entries WHERE feed_url = ? AND guid = ?)",
+			sub.URL, entry.GUID).Scan(&exists)
+		if err != nil {
+			return append(errors, fmt.Errorf("check feed entry %s: %w", entry.GUID, err))
+		}
+		if exists {
+			continue
+		}
+
+		var doc *Document
+		if sub.Articles && entry.Link != "" {
+			doc, err = FetchDocument(entry.Link)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("%s: %w", entry.Link, err))
+				continue
+			}
+			setFeedEntryMetadata(doc, feed, entry)
+		} else {
+			doc = feedEntryDocument(sub.URL, feed, entry)
+		}
+
+		if err := storeDocument(context.WithoutCancel(ctx), db, doc); err != nil {
+			errors = append(errors, fmt.Errorf("%s: %w", doc.Path, err))
+			continue
+		}
+
+		err = writerFor(db).write(func(tx *sql.Tx) error {
+			_, err := tx.Exec(
+				"INSERT OR REPLACE INTO feed_entries (feed_url, guid, path) VALUES (?, ?, ?)",
+				sub.URL, entry.GUID, doc.Path)
+			return err
+		})
+		if err != nil {
+			errors = append(errors, fmt.Errorf("save feed entry %s: %w", entry.GUID, err))
+		}
+	}
+
+	if feed.Title != "" {
+		sub.Title = feed.Title
+	}
+	sub.LastSynced = time.Now().UTC()
+	if err := saveFeed(db, &sub); err != nil {
+		errors = append(errors, err)
+	}
+
+	return errors
+}
+
+// CopyFeeds copies the registered feeds and the entries seen for them
+// to another database
+func CopyFeeds(src, dst *sql.DB) error {
+	feeds, err := GetFeeds(src)
+	if err != nil {
+		return err
+	}
+
+	rows, err := src.Query("SELECT feed_url, guid, path FROM feed_entries")
+	if err != nil {
+		return fmt.Errorf("failed to query feed entries: %v", err)
+	}
+	defer rows.Close()
+
+	var entries [][3]string
+	for rows.Next() {
+		var feedURL, guid, path string
+		if err := rows.Scan(&feedURL, &guid, &path); err != nil {
+			return fmt.Errorf("failed to scan feed entry: %v", err)
+		}
+		entries = append(entries, [3]string{feedURL, guid, path})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating feed entries: %v", err)
+	}
+
+	return writerFor(dst).write(func(tx *sql.Tx) error {
+		for _, sub := range feeds {
+			if err := insertFeed(tx, &sub); err != nil {
+				return err
+			}
+		}
+
+		for _, entry := range entries {
+			_, err := tx.Exec(
+				"INSERT OR REPLACE INTO feed_entries (feed_url, guid, path) VALUES (?, ?, ?)",
+				entry[0], entry[1], entry[2])
+			if err != nil {
+				return fmt.Errorf("copy feed entry %s: %w", entry[1], err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newTestDatabase creates an initialized database and serves mux along
+// with an embedding API that returns the same embedding for every text.
+// It returns the database, its path and the URL of the server.
+func newTestDatabase(t *testing.T, mux *http.ServeMux) (*sql.DB, string, string) {
+	t.Helper()
+
+	mux.HandleFunc("/api/embeddings", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"embedding": [0.5, 0.5]}`)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	previousURL, previousModel := BaseURL, Model
+	BaseURL, Model = server.URL+"/api/embeddings", "test"
+	t.Cleanup(func() { BaseURL, Model = previousURL, previousModel })
+
+	dir := t.TempDir()
+	setIndexRoot(t, dir)
+
+	path := filepath.Join(dir, "referdb")
+	db, _, err := CreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDatabase(db) })
+
+	if err := InitDatabase(db, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveConfig(db, map[string]string{"embedding_model": Model, "embedding_size": "2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	return db, path, server.URL
+}
+
+func TestSyncFeedArticlesKeepMetadataOnReindex(t *testing.T) {
+	var version atomic.Int32
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/feed.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Example blog</title>
+<item>
+	<title>Release notes</title>
+	<link>http://%s/article</link>
+	<guid>release-1</guid>
+	<pubDate>Mon, 02 Jan 2006 15:04:05 GMT</pubDate>
+	<description>Summary</description>
+</item>
+</channel></rss>`, r.Host)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><article><h1>Release notes</h1><p>Version %d. %s</p></article></body></html>",
+			version.Add(1), strings.Repeat("The release fixes bugs and adds features. ", 10))
+	})
+
+	db, dbPath, serverURL := newTestDatabase(t, mux)
+	ctx := context.Background()
+
+	feedURL := serverURL + "/feed.xml"
+	articleURL := serverURL + "/article"
+
+	if _, err := AddFeed(ctx, db, feedURL, true); err != nil {
+		t.Fatalf("AddFeed: %v", err)
+	}
+	if errs := SyncFeeds(ctx, db); len(errs) > 0 {
+		t.Fatalf("SyncFeeds: %v", errs)
+	}
+
+	want := map[string]string{
+		"feed":      "Example blog",
+		"guid":      "release-1",
+		"link":      articleURL,
+		"published": "2006-01-02T15:04:05Z",
+	}
+	checkMetadata := func(db *sql.DB) {
+		t.Helper()
+
+		doc := GetDocumentByPath(db, articleURL)
+		if doc == nil {
+			t.Fatalf("%s was not added", articleURL)
+		}
+		for key, value := range want {
+			if doc.Metadata[key] != value {
+				t.Errorf("metadata %s = %q, want %q", key, doc.Metadata[key], value)
+			}
+		}
+	}
+	checkMetadata(db)
+
+	result, err := Reindex(ctx, db, dbPath, ReindexOptions{EmbeddingSize: 2, NoBackup: true})
+	if err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if result.Changed != 1 {
+		t.Errorf("Reindex() changed %d documents, want the article to be embedded again", result.Changed)
+	}
+
+	reindexed, _, err := CreateDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reindexed.Close()
+
+	checkMetadata(reindexed)
+}
@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// gitLogPrefix is the prefix used for the path of documents created
+	// from commits. The path looks like gitlog://<repo>@<hash>.
+	gitLogPrefix = "gitlog://"
+	// gitLogDiffSuffix and gitLogNoDiffSuffix state whether a commit is
+	// added with its diff hunks. Documents are stored without them so
+	// that each commit is indexed once, whether the diff is included is
+	// kept in the metadata.
+	gitLogDiffSuffix   = "?diff"
+	gitLogNoDiffSuffix = "?nodiff"
+	// metadataGitDiff is set on commit documents which include diffs
+	metadataGitDiff = "diff"
+)
+
+// gitRepository is an opened repository. go-git repositories are not
+// safe for concurrent use, so access is guarded by a mutex.
+type gitRepository struct {
+	sync.Mutex
+	*git.Repository
+}
+
+// gitRepositories caches opened repositories so that fetching many
+// commits from the same repository does not reopen it every time
+var gitRepositories sync.Map
+
+// isGitLogPath checks if the path refers to a commit
+func isGitLogPath(path string) bool {
+	return strings.HasPrefix(path, gitLogPrefix)
+}
+
+// gitLogPath builds the document path for a commit
+func gitLogPath(repoPath string, hash plumbing.Hash) string {
+	return gitLogPrefix + repoPath + "@" + hash.String()
+}
+
+// gitLogAddPath builds the path a commit is added with, which states
+// whether the diff is included
+func gitLogAddPath(repoPath string, hash plumbing.Hash, diff bool) string {
+	if diff {
+		return gitLogPath(repoPath, hash) + gitLogDiffSuffix
+	}
+	return gitLogPath(repoPath, hash) + gitLogNoDiffSuffix
+}
+
+// parseGitLogPath splits a commit path into the repository path, commit
+// hash and the suffix stating whether the diff is included, which is
+// empty for the paths documents are stored with
+func parseGitLogPath(path string) (string, plumbing.Hash, string, error) {
+	rest := strings.TrimPrefix(path, gitLogPrefix)
+
+	suffix := ""
+	for _, s := range []string{gitLogDiffSuffix, gitLogNoDiffSuffix} {
+		if trimmed, ok := strings.CutSuffix(rest, s); ok {
+			rest, suffix = trimmed, s
+			break
+		}
+	}
+
+	i := strings.LastIndex(rest, "@")
+	if i == -1 || !plumbing.IsHash(rest[i+1:]) {
+		return "", plumbing.ZeroHash, "", fmt.Errorf("invalid commit path: %s", path)
+	}
+
+	return rest[:i], plumbing.NewHash(rest[i+1:]), suffix, nil
+}
+
+// openGitRepository opens the git repository containing path
+func openGitRepository(path string) (*gitRepository, error) {
+	if repo, ok := gitRepositories.Load(path); ok {
+		return repo.(*gitRepository), nil
+	}
+
+	opened, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("open git repository %s: %w", path, err)
+	}
+
+	repo, _ := gitRepositories.LoadOrStore(path, &gitRepository{Repository: opened})
+	return repo.(*gitRepository), nil
+}
+
+// ExpandGitLog returns the paths of the commits reachable from HEAD in
+// the repository which are not already in the database
+func ExpandGitLog(db *sql.DB, repoPath string, diff bool) ([]string, error) {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve path %s: %w", repoPath, err)
+	}
+
+	repo, err := openGitRepository(absPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	indexed, err := indexedCommits(db)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repo.Log(&git.LogOptions{Order: git.LogOrderCommitterTime})
+	if err != nil {
+		return nil, fmt.Errorf("read git log: %w", err)
+	}
+	defer iter.Close()
+
+	var paths []string
+	err = iter.ForEach(func(c *object.Commit) error {
+		// Commits indexed without the diff that is requested now, or
+		// the other way around, are added again to replace them
+		withDiff, ok := indexed[gitLogPath(absPath, c.Hash)]
+		if !ok || withDiff != diff {
+			paths = append(paths, gitLogAddPath(absPath, c.Hash, diff))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate git log: %w", err)
+	}
+
+	return paths, nil
+}
+
+// indexedCommits returns the paths of the commit documents in the
+// database mapped to whether they include diffs
+func indexedCommits(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT d.filepath, m.value IS NOT NULL
+		FROM documents d
+		LEFT JOIN document_metadata m ON m.filepath = d.filepath AND m.key = ?
+		WHERE d.filepath LIKE ?`, metadataGitDiff, gitLogPrefix+"%")
+	if err != nil {
+		return nil, fmt.Errorf("query commits: %w", err)
+	}
+	defer rows.Close()
+
+	indexed := map[string]bool{}
+	for rows.Next() {
+		var path string
+		var diff bool
+		if err := rows.Scan(&path, &diff); err != nil {
+			return nil, fmt.Errorf("scan commit: %w", err)
+		}
+		indexed[path] = diff
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating commits: %w", err)
+	}
+
+	return indexed, nil
+}
+
+// refetchGitCommit fetches a commit that is already indexed, including
+// the diff if the previous version did
+func refetchGitCommit(path string, previous *Document) (*Document, error) {
+	repoPath, hash, suffix, err := parseGitLogPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := suffix == gitLogDiffSuffix ||
+		(suffix == "" && previous.Metadata[metadataGitDiff] != "")
+	return fetchGitCommit(gitLogAddPath(repoPath, hash, diff))
+}
+
+// fetchGitCommit creates a document from a single commit containing
+// its message, the changed paths and optionally the diff
+func fetchGitCommit(path string) (*Document, error) {
+	repoPath, hash, suffix, err := parseGitLogPath(path)
+	if err != nil {
+		return nil, err
+	}
+	diff := suffix == gitLogDiffSuffix
+
+	repo, err := openGitRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	commit, err := repo.CommitObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("read commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("read tree for %s: %w", hash, err)
+	}
+
+	// Merge commits are compared against their first parent
+	var parentTree *object.Tree
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("read parent of %s: %w", hash, err)
+		}
+
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("read parent tree for %s: %w", hash, err)
+		}
+	}
+
+	changes, err := object.DiffTree(parentTree, tree)
+	if err != nil {
+		return nil, fmt.Errorf("diff commit %s: %w", hash, err)
+	}
+
+	var content strings.Builder
+	content.WriteString(strings.TrimSpace(commit.Message))
+
+	if len(changes) > 0 {
+		content.WriteString("\n\nChanged files:\n")
+		for _, change := range changes {
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+			content.WriteString("- " + name + "\n")
+		}
+	}
+
+	if diff && len(changes) > 0 {
+		patch, err := changes.Patch()
+		if err != nil {
+			return nil, fmt.Errorf("create patch for %s: %w", hash, err)
+		}
+
+		content.WriteString("\n")
+		content.WriteString(patch.String())
+	}
+
+	title, _, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+
+	metadata := map[string]string{
+		"commit":     hash.String(),
+		"repository": repoPath,
+		"author":     commit.Author.Name + " <" + commit.Author.Email + ">",
+		"date":       commit.Author.When.Format(time.RFC3339),
+	}
+	if diff {
+		metadata[metadataGitDiff] = "true"
+	}
+
+	return &Document{
+		Path:     gitLogPath(repoPath, hash),
+		Content:  strings.TrimSpace(content.String()),
+		Title:    title,
+		IsRemote: false,
+		Metadata: metadata,
+	}, nil
+}
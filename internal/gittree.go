@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// gitTreePrefix is the prefix used for files read from a git revision.
+// Sources look like git://<repo>@<rev> and the documents within them
+// are stored as git://<repo>@<rev>:<path>.
+const gitTreePrefix = "git://"
+
+// IsGitTreeURL checks if the path refers to a git revision or a file
+// within one
+func IsGitTreeURL(path string) bool {
+	return strings.HasPrefix(path, gitTreePrefix)
+}
+
+// GitTreeURL builds the source path for a revision of a repository
+func GitTreeURL(repoPath, rev string) (string, error) {
+	absPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("resolve path %s: %w", repoPath, err)
+	}
+
+	return gitTreePrefix + absPath + "@" + rev, nil
+}
+
+// parseGitTreeURL splits git://<repo>@<rev>[:<path>] into its parts.
+// Revisions cannot contain ":", so the first ":" after the "@" of the
+// revision separates it from the file path. Repository paths can
+// contain ":" as well, either as a Windows drive letter which comes
+// before the "@" of the revision or as the host separator of an
+// scp-style user@host:path remote, which is skipped.
+func parseGitTreeURL(url string) (string, string, string, error) {
+	rest := strings.TrimPrefix(url, gitTreePrefix)
+
+	start := 0
+	if user, remote, ok := strings.Cut(rest, "@"); ok && !strings.ContainsAny(user, `/\`) {
+		if _, path, ok := strings.Cut(remote, ":"); ok && strings.Contains(path, "@") {
+			start = len(rest) - len(path)
+		}
+	}
+
+	at := strings.LastIndex(rest, "@")
+	if first := strings.Index(rest[start:], "@"); first != -1 {
+		first += start
+		if colon := strings.Index(rest[first:], ":"); colon != -1 {
+			at = strings.LastIndex(rest[:first+colon], "@")
+		}
+	}
+	if at == -1 {
+		return "", "", "", fmt.Errorf("missing revision in %s", url)
+	}
+
+	repoPath := rest[:at]
+	rev, file, _ := strings.Cut(rest[at+1:], ":")
+	if repoPath == "" || rev == "" {
+		return "", "", "", fmt.Errorf("invalid git url: %s", url)
+	}
+
+	return repoPath, rev, file, nil
+}
+
+// resolveGitTree returns the tree and commit for a revision
+func resolveGitTree(repo *gitRepository, rev string) (*object.Tree, *object.Commit, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, nil, fmt.Errorf("resolve revision %s: %w", rev, err)
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read commit %s: %w", hash, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read tree for %s: %w", hash, err)
+	}
+
+	return tree, commit, nil
+}
+
+// ExpandGitTree returns the paths of all the text files in the tree of
+// a revision without checking it out. A relative repository path is
+// resolved against the current directory, the documents are stored with
+// the absolute one.
+func ExpandGitTree(url string) ([]string, error) {
+	repoPath, rev, _, err := parseGitTreeURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	repoPath, err = filepath.Abs(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("resolve path %s: %w", repoPath, err)
+	}
+
+	repo, err := openGitRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	tree, _, err := resolveGitTree(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	err = tree.Files().ForEach(func(f *object.File) error {
+		binary, err := f.IsBinary()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", f.Name, err)
+		}
+
+		if !binary {
+			paths = append(paths, gitTreePrefix+repoPath+"@"+rev+":"+f.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk tree for %s: %w", rev, err)
+	}
+
+	return paths, nil
+}
+
+// fetchGitTreeFile reads a single file from the tree of a revision
+func fetchGitTreeFile(url string) (*Document, error) {
+	repoPath, rev, name, err := parseGitTreeURL(url)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing file path in %s", url)
+	}
+
+	repo, err := openGitRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	repo.Lock()
+	defer repo.Unlock()
+
+	tree, commit, err := resolveGitTree(repo, rev)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := tree.File(name)
+	if err != nil {
+		return nil, fmt.Errorf("read %s at %s: %w", name, rev, err)
+	}
+
+	reader, err := file.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("read %s at %s: %w", name, rev, err)
+	}
+	defer reader.Close()
+
+	raw, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("read %s at %s: %w", name, rev, err)
+	}
+
+	content, ok := decodeText(raw)
+	if !ok {
+		return nil, fmt.Errorf("not a text file: %s at %s", name, rev)
+	}
+
+	doc := &Document{
+		Path:     name,
+		Content:  content,
+		Title:    rev + ":" + name,
+		IsRemote: false,
+	}
+
+	if isMarkdownFile(name) {
+		parseMarkdownDocument(doc)
+
+		// Links are parsed relative to the tree, point them back into it
+		for i, link := range doc.Links {
+			if link.Kind == LinkKindPath {
+				doc.Links[i].Target = gitTreePrefix + repoPath + "@" + rev + ":" + link.Target
+			}
+		}
+	}
+
+	if doc.Metadata == nil {
+		doc.Metadata = map[string]string{}
+	}
+	doc.Metadata["revision"] = rev
+	doc.Metadata["commit"] = commit.Hash.String()
+
+	doc.Path = url
+	return doc, nil
+}
@@ -0,0 +1,283 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultUserAgent is sent with every request for remote documents
+	defaultUserAgent = "refer"
+	// defaultFetchTimeout is the timeout used when a host does not
+	// configure one
+	defaultFetchTimeout = 60 * time.Second
+	// maxRedirects is the number of redirects followed, the same as the
+	// default of net/http
+	maxRedirects = 10
+)
+
+// HostConfig holds the settings used when fetching documents from a
+// host. String values are expanded using environment variables so that
+// secrets can be kept out of the config file (eg: "$WIKI_TOKEN").
+type HostConfig struct {
+	Headers     map[string]string `json:"headers,omitempty"`
+	BearerToken string            `json:"bearer_token,omitempty"`
+	BasicAuth   *struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"basic_auth,omitempty"`
+	// Path to a cookies.txt file in the Netscape format
+	CookieFile string `json:"cookie_file,omitempty"`
+	// Request timeout (eg: "30s")
+	Timeout   string `json:"timeout,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Path to a PEM file with additional CA certificates
+	CABundle string `json:"ca_bundle,omitempty"`
+	// Proxy URL, defaults to the proxy from the environment
+	Proxy string `json:"proxy,omitempty"`
+}
+
+// HostConfigs maps host names to the settings used to fetch from them.
+// Keys can be an exact host ("wiki.example.com"), a wildcard for
+// subdomains ("*.example.com") or "*" for all hosts.
+var HostConfigs = map[string]HostConfig{}
+
+// httpClients caches a client per host config so that connections and
+// cookies are reused across requests
+var httpClients sync.Map
+
+// hostConfigFor returns the key and config that applies to the host
+func hostConfigFor(host string) (string, HostConfig) {
+	hostname := host
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		hostname = h
+	}
+
+	for _, key := range []string{host, hostname} {
+		if cfg, ok := HostConfigs[key]; ok {
+			return key, cfg
+		}
+	}
+
+	// Most specific wildcard wins
+	best := ""
+	for key := range HostConfigs {
+		suffix, ok := strings.CutPrefix(key, "*.")
+		if !ok {
+			continue
+		}
+		if (hostname == suffix || strings.HasSuffix(hostname, "."+suffix)) && len(key) > len(best) {
+			best = key
+		}
+	}
+	if best != "" {
+		return best, HostConfigs[best]
+	}
+
+	if cfg, ok := HostConfigs["*"]; ok {
+		return "*", cfg
+	}
+
+	return "", HostConfig{}
+}
+
+// newRemoteRequest creates a request for a remote document along with
+// the client that should be used to send it, applying the settings
+// configured for the host
+func newRemoteRequest(ctx context.Context, method, rawURL string) (*http.Request, *http.Client, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create request: %w", err)
+	}
+
+	key, cfg := hostConfigFor(req.URL.Host)
+
+	client, err := httpClientFor(key, cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("configure client for %s: %w", req.URL.Host, err)
+	}
+
+	applyHostConfig(req, cfg)
+
+	return req, client, nil
+}
+
+// applyHostConfig sets the headers configured for a host on a request
+func applyHostConfig(req *http.Request, cfg HostConfig) {
+	userAgent := defaultUserAgent
+	if cfg.UserAgent != "" {
+		userAgent = os.ExpandEnv(cfg.UserAgent)
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	for name, value := range cfg.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	switch {
+	case cfg.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(cfg.BearerToken))
+	case cfg.BasicAuth != nil:
+		req.SetBasicAuth(os.ExpandEnv(cfg.BasicAuth.Username), os.ExpandEnv(cfg.BasicAuth.Password))
+	}
+}
+
+// checkRedirect follows up to maxRedirects redirects. The client copies
+// the headers of the first request to every redirect, so when the host
+// changes the headers configured for the first host are replaced with
+// the ones configured for the new host. This keeps secrets in custom
+// headers from being sent to other hosts.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	first := via[0]
+	if req.URL.Host == first.URL.Host {
+		return nil
+	}
+
+	_, previous := hostConfigFor(first.URL.Host)
+	for name := range previous.Headers {
+		req.Header.Del(name)
+	}
+	req.Header.Del("Authorization")
+
+	_, cfg := hostConfigFor(req.URL.Host)
+	applyHostConfig(req, cfg)
+
+	return nil
+}
+
+// httpClientFor returns the client for a host config, creating it on
+// first use
+func httpClientFor(key string, cfg HostConfig) (*http.Client, error) {
+	if client, ok := httpClients.Load(key); ok {
+		return client.(*http.Client), nil
+	}
+
+	timeout := defaultFetchTimeout
+	if cfg.Timeout != "" {
+		parsed, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %w", cfg.Timeout, err)
+		}
+		timeout = parsed
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(os.ExpandEnv(cfg.Proxy))
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %w", cfg.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if cfg.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		pem, err := os.ReadFile(os.ExpandEnv(cfg.CABundle))
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.CABundle)
+		}
+
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client := &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}
+
+	if cfg.CookieFile != "" {
+		jar, err := loadCookieJar(os.ExpandEnv(cfg.CookieFile))
+		if err != nil {
+			return nil, err
+		}
+		client.Jar = jar
+	}
+
+	actual, _ := httpClients.LoadOrStore(key, client)
+	return actual.(*http.Client), nil
+}
+
+// loadCookieJar reads cookies from a file in the Netscape cookies.txt
+// format as exported by browsers and curl
+func loadCookieJar(path string) (http.CookieJar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open cookie file: %w", err)
+	}
+	defer f.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create cookie jar: %w", err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line = rest
+			httpOnly = true
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			continue
+		}
+
+		domain, path, secure, expiry, name, value := fields[0], fields[2], fields[3], fields[4], fields[5], fields[6]
+
+		cookie := &http.Cookie{
+			Name:     name,
+			Value:    value,
+			Path:     path,
+			Domain:   domain,
+			Secure:   strings.EqualFold(secure, "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if seconds, err := strconv.ParseInt(expiry, 10, 64); err == nil && seconds > 0 {
+			cookie.Expires = time.Unix(seconds, 0)
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: strings.TrimPrefix(domain, "."), Path: path}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read cookie file: %w", err)
+	}
+
+	return jar, nil
+}
@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// LinkKindWiki is an Obsidian style [[wikilink]] which refers to a
+	// document by name
+	LinkKindWiki = "wiki"
+	// LinkKindPath is a regular markdown link which refers to a
+	// document by its path
+	LinkKindPath = "path"
+)
+
+// Link is a reference from one document to another
+type Link struct {
+	Target string
+	Kind   string
+}
+
+// saveDocumentLinks replaces the outgoing links stored for a document
+func saveDocumentLinks(db execer, path string, links []Link) error {
+	if _, err := db.Exec("DELETE FROM document_links WHERE source = ?", path); err != nil {
+		return fmt.Errorf("delete existing links: %w", err)
+	}
+
+	for _, link := range links {
+		if _, err := db.Exec(
+			"INSERT OR IGNORE INTO document_links (source, target, kind) VALUES (?, ?, ?)",
+			path, link.Target, link.Kind); err != nil {
+			return fmt.Errorf("insert link %s: %w", link.Target, err)
+		}
+	}
+
+	return nil
+}
+
+// getDocumentOutgoingLinks returns the links stored for a document
+func getDocumentOutgoingLinks(db *sql.DB, path string) ([]Link, error) {
+	stored, err := getLinks(db, "SELECT source, target, kind FROM document_links WHERE source = ?", path)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, 0, len(stored))
+	for _, link := range stored {
+		links = append(links, link.Link)
+	}
+
+	return links, nil
+}
+
+// linkResolver maps link targets to the documents they refer to
+type linkResolver struct {
+	byPath map[string]Document
+	byName map[string][]Document
+}
+
+func newLinkResolver(db *sql.DB) (*linkResolver, error) {
+	rows, err := db.Query("SELECT id, filepath, title FROM documents")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query documents: %v", err)
+	}
+	defer rows.Close()
+
+	r := &linkResolver{
+		byPath: map[string]Document{},
+		byName: map[string][]Document{},
+	}
+
+	for rows.Next() {
+		var doc Document
+		if err := rows.Scan(&doc.ID, &doc.Path, &doc.Title); err != nil {
+			return nil, fmt.Errorf("failed to scan document: %v", err)
+		}
+
+		r.byPath[filepath.Clean(doc.Path)] = doc
+
+		base := filepath.Base(doc.Path)
+		name := strings.TrimSuffix(base, filepath.Ext(base))
+		r.addName(name, doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %v", err)
+	}
+
+	// Aliases from front matter can also be used as link targets
+	aliasRows, err := db.Query("SELECT filepath, value FROM document_metadata WHERE key = 'aliases'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query aliases: %v", err)
+	}
+	defer aliasRows.Close()
+
+	for aliasRows.Next() {
+		var path, aliases string
+		if err := aliasRows.Scan(&path, &aliases); err != nil {
+			return nil, fmt.Errorf("failed to scan aliases: %v", err)
+		}
+
+		doc, ok := r.byPath[filepath.Clean(path)]
+		if !ok {
+			continue
+		}
+		for _, alias := range strings.Split(aliases, ",") {
+			r.addName(alias, doc)
+		}
+	}
+	if err := aliasRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating aliases: %v", err)
+	}
+
+	return r, nil
+}
+
+func (r *linkResolver) addName(name string, doc Document) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return
+	}
+	if slices.ContainsFunc(r.byName[name], func(d Document) bool { return d.ID == doc.ID }) {
+		return
+	}
+	r.byName[name] = append(r.byName[name], doc)
+}
+
+// resolve returns the documents a link refers to
+func (r *linkResolver) resolve(link Link) []Document {
+	switch link.Kind {
+	case LinkKindPath:
+		if doc, ok := r.byPath[filepath.Clean(link.Target)]; ok {
+			return []Document{doc}
+		}
+		if doc, ok := r.byPath[filepath.Clean(link.Target+".md")]; ok {
+			return []Document{doc}
+		}
+	case LinkKindWiki:
+		target := strings.ToLower(link.Target)
+		if docs, ok := r.byName[target]; ok {
+			return docs
+		}
+
+		// [[folder/note]] style links refer to a path suffix
+		var docs []Document
+		for path, doc := range r.byPath {
+			p := strings.ToLower(strings.TrimSuffix(path, filepath.Ext(path)))
+			if p == target || strings.HasSuffix(p, "/"+target) {
+				docs = append(docs, doc)
+			}
+		}
+		return docs
+	}
+
+	return nil
+}
+
+// GetDocumentLinks returns the links going out of a document along
+// with the documents they resolve to. Links to documents which are not
+// in the database are returned with an ID of 0.
+func GetDocumentLinks(db *sql.DB, id int) ([]Document, error) {
+	doc, err := GetDocumentByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("no document found with ID %d", id)
+	}
+
+	resolver, err := newLinkResolver(db)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := getLinks(db, "SELECT source, target, kind FROM document_links WHERE source = ?", doc.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	for _, link := range links {
+		resolved := resolver.resolve(link.Link)
+		if len(resolved) == 0 {
+			docs = append(docs, Document{Path: link.Target, Title: link.Target})
+			continue
+		}
+		docs = append(docs, resolved...)
+	}
+
+	return docs, nil
+}
+
+// GetDocumentBacklinks returns the documents which link to a document
+func GetDocumentBacklinks(db *sql.DB, id int) ([]Document, error) {
+	doc, err := GetDocumentByID(db, id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("no document found with ID %d", id)
+	}
+
+	resolver, err := newLinkResolver(db)
+	if err != nil {
+		return nil, err
+	}
+
+	links, err := getLinks(db, "SELECT source, target, kind FROM document_links")
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []Document
+	seen := map[string]bool{}
+	for _, link := range links {
+		if seen[link.Source] {
+			continue
+		}
+
+		for _, target := range resolver.resolve(link.Link) {
+			if target.ID != doc.ID {
+				continue
+			}
+
+			if source, ok := resolver.byPath[filepath.Clean(link.Source)]; ok {
+				docs = append(docs, source)
+				seen[link.Source] = true
+			}
+			break
+		}
+	}
+
+	return docs, nil
+}
+
+type storedLink struct {
+	Link
+	Source string
+}
+
+func getLinks(db *sql.DB, query string, args ...any) ([]storedLink, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query links: %v", err)
+	}
+	defer rows.Close()
+
+	var links []storedLink
+	for rows.Next() {
+		var link storedLink
+		if err := rows.Scan(&link.Source, &link.Target, &link.Kind); err != nil {
+			return nil, fmt.Errorf("failed to scan link: %v", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating links: %v", err)
+	}
+
+	return links, nil
+}
+
+// BoostLinkedDocuments reduces the distance of search results which
+// are linked from one of the top hits. A boost of 0.2 reduces the
+// distance of linked documents by 20%. The boost has to be between 0
+// and 1 so that linked documents are moved up by a bounded amount.
+func BoostLinkedDocuments(db *sql.DB, docs []Document, topHits int, boost float64) error {
+	if boost <= 0 || boost >= 1 {
+		return fmt.Errorf("link boost must be between 0 and 1, got %v", boost)
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+
+	resolver, err := newLinkResolver(db)
+	if err != nil {
+		return err
+	}
+
+	sorted := slices.Clone(docs)
+	slices.SortFunc(sorted, func(i, j Document) int {
+		return int((i.Distance - j.Distance) * 1000)
+	})
+
+	linked := map[int64]bool{}
+	for _, hit := range sorted[:min(topHits, len(sorted))] {
+		links, err := getLinks(db, "SELECT source, target, kind FROM document_links WHERE source = ?", hit.Path)
+		if err != nil {
+			return err
+		}
+
+		for _, link := range links {
+			for _, target := range resolver.resolve(link.Link) {
+				if target.ID != hit.ID {
+					linked[target.ID] = true
+				}
+			}
+		}
+	}
+
+	for i := range docs {
+		if linked[docs[i].ID] {
+			docs[i].Distance *= 1 - boost
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// errLocked is returned when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// lockFile is not supported on this platform, concurrent runs are not
+// prevented
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// errLocked is returned when another process holds the lock
+var errLocked = errors.New("locked by another process")
+
+// lockFile takes an exclusive lock on the file without waiting. The lock
+// is released when the file is closed or the process exits.
+func lockFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errLocked
+	}
+
+	return err
+}
@@ -0,0 +1,307 @@
+package internal
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterKeys are the front matter fields that are kept as
+// document metadata
+var frontMatterKeys = []string{"title", "tags", "date", "aliases"}
+
+// isMarkdownFile checks if the path looks like a markdown document
+func isMarkdownFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown", ".mdx":
+		return true
+	}
+	return false
+}
+
+// parseFrontMatter splits YAML (---) or TOML (+++) front matter from
+// the rest of the markdown content. If there is no front matter or it
+// cannot be parsed, the content is returned as is.
+func parseFrontMatter(content string) (map[string]any, string) {
+	normalized := strings.ReplaceAll(content, "\r\n", "\n")
+
+	var delimiter string
+	switch {
+	case strings.HasPrefix(normalized, "---\n"):
+		delimiter = "---"
+	case strings.HasPrefix(normalized, "+++\n"):
+		delimiter = "+++"
+	default:
+		return nil, content
+	}
+
+	rest := normalized[len(delimiter)+1:]
+	end := strings.Index(rest, "\n"+delimiter)
+	if end == -1 {
+		return nil, content
+	}
+
+	raw := rest[:end]
+	body := strings.TrimPrefix(rest[end+len(delimiter)+1:], "\n")
+
+	fm := map[string]any{}
+	var err error
+	if delimiter == "---" {
+		err = yaml.Unmarshal([]byte(raw), &fm)
+	} else {
+		err = toml.Unmarshal([]byte(raw), &fm)
+	}
+	if err != nil {
+		return nil, content
+	}
+
+	return fm, body
+}
+
+// frontMatterValue flattens a front matter value into a string
+// suitable for storing as metadata
+func frontMatterValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return strings.TrimSpace(v)
+	case time.Time:
+		if v.Hour() == 0 && v.Minute() == 0 && v.Second() == 0 {
+			return v.Format(time.DateOnly)
+		}
+		return v.Format(time.RFC3339)
+	case []any:
+		var items []string
+		for _, item := range v {
+			if s := frontMatterValue(item); s != "" {
+				items = append(items, s)
+			}
+		}
+		return strings.Join(items, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// firstHeading returns the text of the first level one heading
+func firstHeading(body string) string {
+	inFence := false
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if isFenceLine(trimmed) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		if level, text := parseHeading(trimmed); level == 1 {
+			return text
+		}
+	}
+
+	return ""
+}
+
+// parseMarkdownDocument populates title and metadata for a markdown
+// document using its front matter and headings
+func parseMarkdownDocument(doc *Document) {
+	fm, body := parseFrontMatter(doc.Content)
+
+	for _, key := range frontMatterKeys {
+		value, ok := fm[key]
+		if !ok {
+			continue
+		}
+
+		if s := frontMatterValue(value); s != "" {
+			if doc.Metadata == nil {
+				doc.Metadata = map[string]string{}
+			}
+			doc.Metadata[key] = s
+		}
+	}
+
+	doc.Links = parseMarkdownLinks(doc.Path, body)
+
+	switch {
+	case doc.Metadata["title"] != "":
+		doc.Title = doc.Metadata["title"]
+	case firstHeading(body) != "":
+		doc.Title = firstHeading(body)
+	}
+}
+
+// markdownSection is the text under a heading along with the path of
+// headings it is nested under (eg: "Guide > Install > Linux"). The path
+// is empty for text before the first heading.
+type markdownSection struct {
+	path string
+	text string
+}
+
+// String returns the section prefixed with its heading path
+func (s markdownSection) String() string {
+	if s.path == "" {
+		return s.text
+	}
+	return s.path + "\n" + s.text
+}
+
+// markdownEmbeddingText returns the markdown body with front matter
+// removed and every section prefixed with the path of headings it is
+// nested under.
+func markdownEmbeddingText(content string) string {
+	var sections []string
+	for _, section := range markdownSections(content) {
+		sections = append(sections, section.String())
+	}
+
+	return strings.Join(sections, "\n\n")
+}
+
+// markdownSections splits the markdown body into the sections under
+// each heading, skipping the front matter and sections without text
+func markdownSections(content string) []markdownSection {
+	_, body := parseFrontMatter(content)
+
+	type heading struct {
+		level int
+		text  string
+	}
+
+	var (
+		sections []markdownSection
+		headings []heading
+		section  []string
+		inFence  bool
+	)
+
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(section, "\n"))
+		section = nil
+		if text == "" {
+			return
+		}
+
+		var path []string
+		for _, h := range headings {
+			path = append(path, h.text)
+		}
+		sections = append(sections, markdownSection{path: strings.Join(path, " > "), text: text})
+	}
+
+	for _, line := range strings.Split(body, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if isFenceLine(trimmed) {
+			inFence = !inFence
+		}
+
+		if !inFence {
+			if level, text := parseHeading(trimmed); level > 0 {
+				flush()
+
+				// Drop headings at the same or deeper level
+				for len(headings) > 0 && headings[len(headings)-1].level >= level {
+					headings = headings[:len(headings)-1]
+				}
+				headings = append(headings, heading{level: level, text: text})
+				continue
+			}
+		}
+
+		section = append(section, line)
+	}
+	flush()
+
+	return sections
+}
+
+// parseHeading parses an ATX heading and returns its level and text.
+// Level is 0 if the line is not a heading.
+func parseHeading(line string) (int, string) {
+	level := 0
+	for level < len(line) && line[level] == '#' {
+		level++
+	}
+
+	if level == 0 || level > 6 {
+		return 0, ""
+	}
+
+	if level < len(line) && line[level] != ' ' && line[level] != '\t' {
+		return 0, ""
+	}
+
+	text := strings.TrimSpace(line[level:])
+	text = strings.TrimSpace(strings.TrimRight(text, "#"))
+	return level, text
+}
+
+func isFenceLine(line string) bool {
+	return strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~")
+}
+
+var (
+	wikiLinkPattern     = regexp.MustCompile(`\[\[([^\]|#]+)(?:#[^\]|]*)?(?:\|[^\]]*)?\]\]`)
+	markdownLinkPattern = regexp.MustCompile(`\[[^\]]*\]\(<?([^)\s>]+)>?(?:\s+"[^"]*")?\)`)
+)
+
+// parseMarkdownLinks extracts [[wikilinks]] and relative markdown links
+// from a markdown document. Relative links are resolved against the
+// directory of the document.
+func parseMarkdownLinks(path, content string) []Link {
+	var links []Link
+	seen := map[Link]bool{}
+	add := func(link Link) {
+		if link.Target == "" || seen[link] {
+			return
+		}
+		seen[link] = true
+		links = append(links, link)
+	}
+
+	inFence := false
+	for _, line := range strings.Split(content, "\n") {
+		if isFenceLine(strings.TrimSpace(line)) {
+			inFence = !inFence
+			continue
+		}
+		if inFence {
+			continue
+		}
+
+		for _, match := range wikiLinkPattern.FindAllStringSubmatch(line, -1) {
+			add(Link{Target: strings.TrimSpace(match[1]), Kind: LinkKindWiki})
+		}
+
+		for _, match := range markdownLinkPattern.FindAllStringSubmatch(line, -1) {
+			target := match[1]
+			if strings.HasPrefix(target, "#") || strings.Contains(target, ":") {
+				continue // anchors, urls and other schemes
+			}
+
+			if i := strings.IndexAny(target, "#?"); i != -1 {
+				target = target[:i]
+			}
+			if unescaped, err := url.PathUnescape(target); err == nil {
+				target = unescaped
+			}
+
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(path), target)
+			}
+			add(Link{Target: filepath.Clean(target), Kind: LinkKindPath})
+		}
+	}
+
+	return links
+}
@@ -0,0 +1,419 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// schemaVersionKey is the config key holding the version of the schema
+// the database was last migrated to
+const schemaVersionKey = "schema_version"
+
+// migration upgrades the schema by one version. Each migration runs in
+// its own write along with the update of the schema version.
+type migration struct {
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations are applied in order, the schema version is the number of
+// migrations that have been applied. Databases created before schema
+// versions were added are at version 0. Never change or reorder
+// migrations that have been released, add new ones instead.
+var migrations = []migration{
+	{
+		description: "create metadata, link, crawl and feed tables",
+		apply:       createAuxiliaryTables,
+	},
+	{
+		description: "move documents out of the vector table into documents and chunks tables",
+		apply:       createChunkTables,
+	},
+	{
+		description: "store document paths relative to the database directory, converting relative paths of files found from the current directory",
+		apply:       storeRelativePaths,
+	},
+}
+
+// CurrentSchemaVersion is the schema version used by this version of
+// refer
+var CurrentSchemaVersion = len(migrations)
+
+// GetSchemaVersion returns the schema version of the database
+func GetSchemaVersion(db *sql.DB) (int, error) {
+	var exists int
+	err := db.QueryRow(
+		"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'config'").Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("check config table: %w", err)
+	}
+	if exists == 0 {
+		return 0, nil
+	}
+
+	var value string
+	err = db.QueryRow("SELECT value FROM config WHERE key = ?", schemaVersionKey).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("query schema version: %w", err)
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema version %q", value)
+	}
+
+	return version, nil
+}
+
+// PendingMigrations returns the descriptions of the migrations that
+// have not been applied to the database yet
+func PendingMigrations(db *sql.DB) ([]string, error) {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if version > CurrentSchemaVersion {
+		return nil, fmt.Errorf(
+			"database schema version %d is newer than %d, please upgrade refer",
+			version, CurrentSchemaVersion)
+	}
+
+	var pending []string
+	for _, m := range migrations[version:] {
+		pending = append(pending, m.description)
+	}
+
+	return pending, nil
+}
+
+// Migrate applies the pending migrations to the database and returns
+// the schema versions before and after
+func Migrate(db *sql.DB) (int, int, error) {
+	from, err := GetSchemaVersion(db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if from > CurrentSchemaVersion {
+		return from, from, fmt.Errorf(
+			"database schema version %d is newer than %d, please upgrade refer",
+			from, CurrentSchemaVersion)
+	}
+
+	for version := from; version < CurrentSchemaVersion; version++ {
+		if err := applyMigration(db, version+1, migrations[version]); err != nil {
+			return from, version, err
+		}
+	}
+
+	return from, CurrentSchemaVersion, nil
+}
+
+func applyMigration(db *sql.DB, version int, m migration) error {
+	return writerFor(db).write(func(tx *sql.Tx) error {
+		if err := m.apply(tx); err != nil {
+			return fmt.Errorf("migrate to schema version %d (%s): %w", version, m.description, err)
+		}
+
+		if _, err := tx.Exec(
+			"INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)",
+			schemaVersionKey, strconv.Itoa(version)); err != nil {
+			return fmt.Errorf("save schema version: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// BackupDatabase writes a copy of the database next to it and returns
+// the path of the copy
+func BackupDatabase(db *sql.DB, path string) (string, error) {
+	backupPath := databaseBackupPath(path)
+	if fileExists(backupPath) {
+		return "", fmt.Errorf("backup %s already exists", backupPath)
+	}
+
+	// VACUUM INTO writes a consistent copy even if there are other
+	// connections to the database
+	if _, err := db.Exec("VACUUM INTO ?", backupPath); err != nil {
+		return "", fmt.Errorf("backup database to %s: %w", backupPath, err)
+	}
+
+	return backupPath, nil
+}
+
+// databaseBackupPath returns the path a backup of the database taken
+// now is written to
+func databaseBackupPath(path string) string {
+	return fmt.Sprintf("%s.backup-%s", path, time.Now().Format("20060102-150405"))
+}
+
+// createAuxiliaryTables creates the regular tables stored alongside the
+// documents
+func createAuxiliaryTables(tx *sql.Tx) error {
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS config (
+			key TEXT PRIMARY KEY,
+			value TEXT
+		)`); err != nil {
+		return fmt.Errorf("create config table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS document_metadata (
+			filepath TEXT,
+			key TEXT,
+			value TEXT,
+			PRIMARY KEY (filepath, key)
+		)`); err != nil {
+		return fmt.Errorf("create document_metadata table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS document_links (
+			source TEXT,
+			target TEXT,
+			kind TEXT,
+			PRIMARY KEY (source, target, kind)
+		)`); err != nil {
+		return fmt.Errorf("create document_links table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS crawl_roots (
+			url TEXT PRIMARY KEY,
+			options TEXT,
+			last_crawled TEXT
+		)`); err != nil {
+		return fmt.Errorf("create crawl_roots table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS feeds (
+			url TEXT PRIMARY KEY,
+			title TEXT,
+			articles INTEGER,
+			added_at TEXT,
+			last_synced TEXT
+		)`); err != nil {
+		return fmt.Errorf("create feeds table: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS feed_entries (
+			feed_url TEXT,
+			guid TEXT,
+			path TEXT,
+			PRIMARY KEY (feed_url, guid)
+		)`); err != nil {
+		return fmt.Errorf("create feed_entries table: %w", err)
+	}
+
+	return nil
+}
+
+// createChunkTables stores documents and their chunks in regular tables
+// and keeps only the embeddings in the vec0 table, linked to the chunks
+// by rowid. Documents from the old vec0 documents table are moved over
+// as a single chunk keeping their ids.
+func createChunkTables(tx *sql.Tx) error {
+	var embeddingSize int
+	err := tx.QueryRow("SELECT value FROM config WHERE key = 'embedding_size'").Scan(&embeddingSize)
+	if err != nil {
+		return fmt.Errorf("read embedding size: %w", err)
+	}
+
+	var legacy int
+	err = tx.QueryRow(`
+		SELECT count(*) FROM sqlite_master
+		WHERE type = 'table' AND name = 'documents' AND sql LIKE '%vec0%'`).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("check documents table: %w", err)
+	}
+
+	documentsTable := "documents"
+	if legacy > 0 {
+		documentsTable = "documents_new"
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`
+		CREATE TABLE %s (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			filepath TEXT NOT NULL UNIQUE,
+			title TEXT NOT NULL DEFAULT '',
+			content TEXT NOT NULL DEFAULT ''
+		)`, documentsTable)); err != nil {
+		return fmt.Errorf("create documents table: %w", err)
+	}
+
+	if _, err := tx.Exec(fmt.Sprintf(`
+		CREATE VIRTUAL TABLE chunk_embeddings USING vec0(
+			embedding float[%d]
+		)`, embeddingSize)); err != nil {
+		return fmt.Errorf("create chunk_embeddings table: %w", err)
+	}
+
+	if legacy > 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO documents_new (id, filepath, title, content)
+			SELECT rowid, filepath, COALESCE(title, ''), COALESCE(content, '')
+			FROM documents`); err != nil {
+			return fmt.Errorf("copy documents: %w", err)
+		}
+
+		// Each document becomes a single chunk with the same id, so the
+		// embedding keeps its rowid
+		if _, err := tx.Exec(`
+			INSERT INTO chunk_embeddings (rowid, embedding)
+			SELECT rowid, embedding FROM documents`); err != nil {
+			return fmt.Errorf("copy embeddings: %w", err)
+		}
+
+		if _, err := tx.Exec("DROP TABLE documents"); err != nil {
+			return fmt.Errorf("drop old documents table: %w", err)
+		}
+
+		if _, err := tx.Exec("ALTER TABLE documents_new RENAME TO documents"); err != nil {
+			return fmt.Errorf("rename documents table: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE chunks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			document_id INTEGER NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+			seq INTEGER NOT NULL,
+			content TEXT NOT NULL,
+			UNIQUE (document_id, seq)
+		)`); err != nil {
+		return fmt.Errorf("create chunks table: %w", err)
+	}
+
+	if legacy > 0 {
+		if _, err := tx.Exec(`
+			INSERT INTO chunks (id, document_id, seq, content)
+			SELECT id, id, 0, content FROM documents`); err != nil {
+			return fmt.Errorf("copy chunks: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(
+		"CREATE INDEX IF NOT EXISTS document_links_target ON document_links (target)"); err != nil {
+		return fmt.Errorf("create document_links index: %w", err)
+	}
+
+	return nil
+}
+
+// storeRelativePaths sets the directory of the database as the index
+// root and stores local document paths relative to it. Older versions
+// stored paths relative to the directory refer was run from, which is
+// not known anymore. Relative paths are only converted if the file is
+// found from the current directory, the others are kept as they are and
+// reported, they then refer to files in the database directory.
+func storeRelativePaths(tx *sql.Tx) error {
+	var root string
+	err := tx.QueryRow("SELECT value FROM config WHERE key = ?", indexRootKey).Scan(&root)
+	if err == nil && root != "" {
+		return nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("read index root: %w", err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("get current directory: %w", err)
+	}
+
+	// The file is empty for in-memory databases
+	var file string
+	err = tx.QueryRow("SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file)
+	if err != nil {
+		return fmt.Errorf("read database path: %w", err)
+	}
+
+	root = cwd
+	if file != "" {
+		root = filepath.Dir(file)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)",
+		indexRootKey, root); err != nil {
+		return fmt.Errorf("save index root: %w", err)
+	}
+
+	var missing []string
+	rewrite := func(path string) string {
+		if !isLocalPath(path) || filepath.IsAbs(path) {
+			return path
+		}
+
+		absPath := filepath.Join(cwd, path)
+		if !localFileExists(absPath) {
+			missing = append(missing, path)
+			return path
+		}
+
+		return storedPathIn(root, absPath)
+	}
+
+	paths, err := rewriteColumn(tx, "SELECT filepath FROM documents", rewrite)
+	if err != nil {
+		return err
+	}
+
+	for old, path := range paths {
+		for _, query := range []string{
+			"UPDATE documents SET filepath = ? WHERE filepath = ?",
+			"UPDATE document_metadata SET filepath = ? WHERE filepath = ?",
+			"UPDATE OR REPLACE document_links SET source = ? WHERE source = ?",
+		} {
+			if _, err := tx.Exec(query, path, old); err != nil {
+				return fmt.Errorf("rewrite path %s: %w", old, err)
+			}
+		}
+	}
+
+	targets, err := rewriteColumn(tx, fmt.Sprintf(
+		"SELECT DISTINCT target FROM document_links WHERE kind = '%s'", LinkKindPath), rewrite)
+	if err != nil {
+		return err
+	}
+
+	for old, target := range targets {
+		if _, err := tx.Exec(
+			"UPDATE OR REPLACE document_links SET target = ? WHERE target = ? AND kind = ?",
+			target, old, LinkKindPath); err != nil {
+			return fmt.Errorf("rewrite link %s: %w", old, err)
+		}
+	}
+
+	for _, path := range missing {
+		fmt.Fprintf(os.Stderr, "Warning: %s was not found from %s, it is kept relative to %s\n", path, cwd, root)
+	}
+
+	return nil
+}
+
+// localFileExists checks if the file a local document path refers to
+// exists, including archives and mbox files holding the document
+func localFileExists(path string) bool {
+	if _, _, ok := splitArchivePath(path); ok {
+		return true
+	}
+	if _, _, ok := splitMboxPath(path); ok {
+		return true
+	}
+
+	return fileExists(path)
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+// createLegacyDatabase creates a database with the schema of versions
+// before schema versions were added, which stored documents along with
+// their embedding in a single vec0 table
+func createLegacyDatabase(t *testing.T, path string, docs []Document) *sql.DB {
+	t.Helper()
+
+	db, _, err := CreateDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { closeDatabase(db) })
+
+	for _, query := range []string{
+		`CREATE VIRTUAL TABLE documents USING vec0(
+			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+			filepath TEXT UNIQUE,
+			content TEXT,
+			title TEXT,
+			embedding float[2]
+		)`,
+		"CREATE TABLE config (key TEXT PRIMARY KEY, value TEXT)",
+		"INSERT INTO config (key, value) VALUES ('embedding_model', 'test'), ('embedding_size', '2')",
+	} {
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, doc := range docs {
+		embedding, err := sqlite_vec.SerializeFloat32([]float32{float32(i), 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = db.Exec(
+			"INSERT INTO documents (filepath, content, title, embedding) VALUES (?, ?, ?, ?)",
+			doc.Path, doc.Content, doc.Title, embedding)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+// chdir changes the current directory for the duration of a test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(previous) })
+}
+
+func TestMigrateLegacyDatabase(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	db := createLegacyDatabase(t, filepath.Join(dir, "referdb"), []Document{
+		{Path: "https://example.com/a", Title: "Page A", Content: "page a"},
+		{Path: "https://example.com/b", Title: "Page B", Content: "page b"},
+	})
+
+	from, to, err := Migrate(db)
+	if err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if from != 0 || to != CurrentSchemaVersion {
+		t.Errorf("Migrate() migrated from %d to %d, want 0 to %d", from, to, CurrentSchemaVersion)
+	}
+
+	docs, err := GetAllDocuments(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Document{
+		{ID: 1, Path: "https://example.com/a", Title: "Page A", Content: "page a"},
+		{ID: 2, Path: "https://example.com/b", Title: "Page B", Content: "page b"},
+	}
+	if !reflect.DeepEqual(docs, want) {
+		t.Fatalf("documents after migration = %+v, want %+v", docs, want)
+	}
+
+	// Every document becomes a single chunk keeping its embedding
+	for i, doc := range docs {
+		chunks, err := GetDocumentChunks(db, doc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		embedding, _ := sqlite_vec.SerializeFloat32([]float32{float32(i), 1})
+		if len(chunks) != 1 || chunks[0].Content != doc.Content || !reflect.DeepEqual(chunks[0].Embedding, embedding) {
+			t.Errorf("chunks of %s = %+v, want its content and embedding", doc.Path, chunks)
+		}
+	}
+
+	results, err := SearchDocuments(db, []float32{1, 1}, 1, nil)
+	if err != nil {
+		t.Fatalf("SearchDocuments: %v", err)
+	}
+	if len(results) != 1 || results[0].Path != "https://example.com/b" {
+		t.Errorf("SearchDocuments() = %+v, want https://example.com/b", results)
+	}
+
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) != 0 {
+		t.Errorf("PendingMigrations() = %v, %v after migrating", pending, err)
+	}
+}
+
+func TestMigrateLegacyRelativePaths(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, "db"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "notes"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes", "a.md"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	db := createLegacyDatabase(t, filepath.Join(dir, "db", "referdb"), []Document{
+		{Path: "notes/a.md", Title: "notes/a.md", Content: "a"},
+		{Path: "notes/missing.md", Title: "notes/missing.md", Content: "missing"},
+		{Path: filepath.Join(dir, "db", "b.md"), Title: "B", Content: "b"},
+		{Path: "https://example.com/c", Title: "C", Content: "c"},
+	})
+
+	if _, _, err := Migrate(db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	config, err := GetConfig(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := config[indexRootKey]; root != filepath.Join(dir, "db") {
+		t.Errorf("index root = %q, want the database directory", root)
+	}
+
+	docs, err := GetAllDocuments(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][2]string
+	for _, doc := range docs {
+		got = append(got, [2]string{doc.Path, doc.Title})
+	}
+
+	// Files found from the current directory are converted, the others
+	// are kept as they are. Titles are never changed.
+	want := [][2]string{
+		{filepath.Join(dir, "notes", "a.md"), "notes/a.md"},
+		{"notes/missing.md", "notes/missing.md"},
+		{filepath.Join(dir, "db", "b.md"), "B"},
+		{"https://example.com/c", "C"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paths and titles after migration = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexRootKey is the config key holding the directory that local
+// document paths are stored relative to
+const indexRootKey = "index_root"
+
+// IndexRoot is the directory that local document paths are relative to.
+// Documents within it are stored with paths relative to it so that the
+// index keeps working when the directory is moved or refer is run from
+// a different directory. Documents outside of it are stored with
+// absolute paths.
+var IndexRoot string
+
+// LoadIndexRoot sets IndexRoot from the database config. Databases that
+// are not initialized yet use the directory of the database, which is
+// the root the migrations save for them.
+func LoadIndexRoot(db *sql.DB, dbPath string) error {
+	version, err := GetSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if version > 0 {
+		config, err := GetConfig(db)
+		if err != nil {
+			return err
+		}
+
+		if root := config[indexRootKey]; root != "" {
+			IndexRoot = root
+			return nil
+		}
+	}
+
+	absPath, err := filepath.Abs(dbPath)
+	if err != nil {
+		return fmt.Errorf("resolve path %s: %w", dbPath, err)
+	}
+	IndexRoot = filepath.Dir(absPath)
+
+	return nil
+}
+
+// isLocalPath checks if a document path refers to a file on disk,
+// including files within archives and messages within mbox files
+func isLocalPath(path string) bool {
+	return !IsRemoteURL(path) &&
+		!isFeedEntryPath(path) &&
+		!isExecPath(path) &&
+		!isStdinPath(path) &&
+		!isGitLogPath(path) &&
+		!IsGitTreeURL(path)
+}
+
+// localPath prefixes a relative path to a file with ./ if it would
+// otherwise look like the path of a document that is not a file, so that
+// a file named like exec:... is never run as a command
+func localPath(path string) string {
+	if filepath.IsAbs(path) || isLocalPath(path) {
+		return path
+	}
+
+	return "." + string(filepath.Separator) + path
+}
+
+// StoredPath converts a local path relative to the current directory
+// into the path stored for it, which is relative to IndexRoot if the
+// file is within it and absolute otherwise
+func StoredPath(path string) string {
+	if !isLocalPath(path) || IndexRoot == "" {
+		return path
+	}
+
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+
+	return storedPathIn(IndexRoot, absPath)
+}
+
+// storedPathIn returns the path stored for an absolute local path with
+// the given index root
+func storedPathIn(root, absPath string) string {
+	rel, err := filepath.Rel(root, absPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return absPath
+	}
+
+	return localPath(rel)
+}
+
+// ResolvePath converts a stored document path into a path that can be
+// read from the current directory
+func ResolvePath(path string) string {
+	if !isLocalPath(path) || filepath.IsAbs(path) || IndexRoot == "" {
+		return path
+	}
+
+	return filepath.Join(IndexRoot, path)
+}
+
+// DisplayPath converts a stored document path into the path shown to
+// the user. Files within the current directory are shown relative to it
+// like they were given to add, other files with their absolute path.
+func DisplayPath(path string) string {
+	if !isLocalPath(path) {
+		return path
+	}
+
+	resolved := ResolvePath(path)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return resolved
+	}
+
+	rel, err := filepath.Rel(cwd, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return resolved
+	}
+
+	return rel
+}
+
+// storeDocumentPaths names a document read from resolved by its stored
+// path. Titles and links derived from the path are converted as well.
+func storeDocumentPaths(doc *Document, resolved, path string) {
+	doc.Path = path
+	if doc.Title == resolved {
+		doc.Title = path
+	}
+
+	// Links are parsed relative to the file that was read
+	for i, link := range doc.Links {
+		if link.Kind == LinkKindPath {
+			doc.Links[i].Target = StoredPath(link.Target)
+		}
+	}
+}
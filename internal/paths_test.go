@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"testing"
+
+	// sqlite-vec links against the SQLite library of the driver, which
+	// only the main package imports otherwise
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// setIndexRoot sets IndexRoot for the duration of a test
+func setIndexRoot(t *testing.T, root string) {
+	previous := IndexRoot
+	IndexRoot = root
+	t.Cleanup(func() { IndexRoot = previous })
+}
+
+func TestStoredPath(t *testing.T) {
+	setIndexRoot(t, "/index")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"within root", "/index/notes/todo.md", "notes/todo.md"},
+		{"root itself", "/index", "."},
+		{"outside root", "/other/todo.md", "/other/todo.md"},
+		{"sibling with same prefix", "/index2/todo.md", "/index2/todo.md"},
+		{"escape with ..", "/index/../other/todo.md", "/other/todo.md"},
+		{"name starting with ..", "/index/..notes/todo.md", "..notes/todo.md"},
+		{"file named like a command", "/index/exec:rm -rf x", "./exec:rm -rf x"},
+		{"file named like stdin", "/index/stdin:notes.txt", "./stdin:notes.txt"},
+		{"archive entry", "/index/bundle.zip!/docs/readme.md", "bundle.zip!/docs/readme.md"},
+		{"command", "exec:ls", "exec:ls"},
+		{"remote", "https://example.com/page", "https://example.com/page"},
+		{"git log", "gitlog:///repo@0123456789abcdef0123456789abcdef01234567", "gitlog:///repo@0123456789abcdef0123456789abcdef01234567"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StoredPath(tt.path); got != tt.want {
+				t.Errorf("StoredPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoredPathWithoutRoot(t *testing.T) {
+	setIndexRoot(t, "")
+
+	if got := StoredPath("notes/todo.md"); got != "notes/todo.md" {
+		t.Errorf("StoredPath() = %q, want the path unchanged", got)
+	}
+}
+
+func TestResolvePath(t *testing.T) {
+	setIndexRoot(t, "/index")
+
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"relative", "notes/todo.md", "/index/notes/todo.md"},
+		{"prefixed with ./", "./exec:rm -rf x", "/index/exec:rm -rf x"},
+		{"name starting with ..", "..notes/todo.md", "/index/..notes/todo.md"},
+		{"absolute", "/other/todo.md", "/other/todo.md"},
+		{"command", "exec:ls", "exec:ls"},
+		{"stdin", "stdin:notes.txt", "stdin:notes.txt"},
+		{"remote", "https://example.com/page", "https://example.com/page"},
+		{"git tree", "git:///repo@main:README.md", "git:///repo@main:README.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolvePath(tt.path); got != tt.want {
+				t.Errorf("ResolvePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoredPathRoundTrip(t *testing.T) {
+	setIndexRoot(t, "/index")
+
+	for _, path := range []string{"/index/notes/todo.md", "/index/exec:ls", "/other/todo.md"} {
+		if got := ResolvePath(StoredPath(path)); got != path {
+			t.Errorf("ResolvePath(StoredPath(%q)) = %q", path, got)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Progress output modes
+const (
+	// ProgressAuto shows a progress bar if stderr is a terminal and
+	// progress lines otherwise
+	ProgressAuto = "auto"
+	// ProgressBar shows a progress bar which is redrawn in place
+	ProgressBar = "bar"
+	// ProgressLines prints a line of key=value pairs periodically, for
+	// logs and other programs
+	ProgressLines = "lines"
+	// ProgressNone disables progress output
+	ProgressNone = "none"
+)
+
+const (
+	// progressBarInterval is how often the progress bar is redrawn
+	progressBarInterval = 200 * time.Millisecond
+	// progressLineInterval is how often a progress line is printed
+	progressLineInterval = 10 * time.Second
+	// progressBarWidth is the number of characters in the progress bar
+	progressBarWidth = 30
+)
+
+type progressKey struct{}
+
+// Progress tracks the documents processed while indexing and reports
+// the progress to stderr. A nil *Progress can be used and does not
+// report anything.
+type Progress struct {
+	mode  string
+	out   io.Writer
+	start time.Time
+
+	lock       sync.Mutex
+	discovered int
+	fetched    int
+	embedded   int
+	skipped    int
+	failed     int
+	// drawn is set if the progress bar is on screen and has to be
+	// cleared before printing anything else
+	drawn bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewProgress creates a progress reporter and starts reporting
+func NewProgress(mode string) (*Progress, error) {
+	switch mode {
+	case ProgressAuto:
+		mode = ProgressLines
+		if isTerminal(os.Stderr) {
+			mode = ProgressBar
+		}
+	case ProgressBar, ProgressLines, ProgressNone:
+	default:
+		return nil, fmt.Errorf("unknown progress mode %q", mode)
+	}
+
+	p := &Progress{
+		mode:  mode,
+		out:   os.Stderr,
+		start: time.Now(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go p.run()
+
+	return p, nil
+}
+
+// WithProgress returns a context which reports to p
+func WithProgress(ctx context.Context, p *Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, p)
+}
+
+// progressFrom returns the progress reporter of a context, nil if there
+// is none
+func progressFrom(ctx context.Context) *Progress {
+	p, _ := ctx.Value(progressKey{}).(*Progress)
+	return p
+}
+
+// isTerminal checks if the file is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Discovered records documents that were found and will be processed
+func (p *Progress) Discovered(n int) {
+	p.update(func() { p.discovered += n })
+}
+
+// Fetched records a document that was read
+func (p *Progress) Fetched() {
+	p.update(func() { p.fetched++ })
+}
+
+// Embedded records a document that was embedded and saved
+func (p *Progress) Embedded() {
+	p.update(func() { p.embedded++ })
+}
+
+// Skipped records a document that did not have to be embedded, because
+// it has not changed or is empty
+func (p *Progress) Skipped() {
+	p.update(func() { p.skipped++ })
+}
+
+// Failed records a document that could not be added
+func (p *Progress) Failed() {
+	p.update(func() { p.failed++ })
+}
+
+func (p *Progress) update(fn func()) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	fn()
+}
+
+// Printf prints a message to stdout, keeping the progress bar below it
+func (p *Progress) Printf(format string, args ...any) {
+	if p == nil {
+		fmt.Printf(format, args...)
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.clear()
+	fmt.Printf(format, args...)
+	if p.mode == ProgressBar {
+		p.draw()
+	}
+}
+
+// Write writes to stderr keeping the progress bar below the output. It
+// is used as the output of the log package while progress is shown.
+func (p *Progress) Write(b []byte) (int, error) {
+	if p == nil {
+		return os.Stderr.Write(b)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.clear()
+	n, err := p.out.Write(b)
+	if p.mode == ProgressBar {
+		p.draw()
+	}
+
+	return n, err
+}
+
+// Stop stops reporting and removes the progress bar
+func (p *Progress) Stop() {
+	if p == nil {
+		return
+	}
+
+	close(p.stop)
+	<-p.done
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.clear()
+}
+
+// Summary writes a table of the final counts
+func (p *Progress) Summary(w io.Writer) {
+	if p == nil {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	elapsed := time.Since(p.start)
+	done := p.embedded + p.skipped + p.failed
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Discovered\t%d\n", p.discovered)
+	fmt.Fprintf(tw, "Fetched\t%d\n", p.fetched)
+	fmt.Fprintf(tw, "Embedded\t%d\n", p.embedded)
+	fmt.Fprintf(tw, "Skipped\t%d\n", p.skipped)
+	fmt.Fprintf(tw, "Failed\t%d\n", p.failed)
+	fmt.Fprintf(tw, "Elapsed\t%s (%.1f documents/s)\n",
+		elapsed.Round(time.Second), float64(done)/elapsed.Seconds())
+	tw.Flush()
+}
+
+func (p *Progress) run() {
+	defer close(p.done)
+
+	if p.mode == ProgressNone {
+		<-p.stop
+		return
+	}
+
+	interval := progressLineInterval
+	if p.mode == ProgressBar {
+		interval = progressBarInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.lock.Lock()
+			if p.mode == ProgressBar {
+				p.draw()
+			} else {
+				p.line()
+			}
+			p.lock.Unlock()
+		}
+	}
+}
+
+// stats returns the number of documents processed, the total and the
+// rate and estimated time remaining. The total grows as more documents
+// are discovered. Callers hold the lock.
+func (p *Progress) stats() (int, int, float64, time.Duration) {
+	done := p.embedded + p.skipped + p.failed
+	total := max(p.discovered, done)
+
+	rate := float64(done) / time.Since(p.start).Seconds()
+
+	var eta time.Duration
+	if rate > 0 {
+		eta = time.Duration(float64(total-done) / rate * float64(time.Second))
+	}
+
+	return done, total, rate, eta
+}
+
+// draw redraws the progress bar. Callers hold the lock.
+func (p *Progress) draw() {
+	done, total, rate, eta := p.stats()
+
+	filled := 0
+	percent := 0
+	if total > 0 {
+		filled = done * progressBarWidth / total
+		percent = done * 100 / total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+
+	status := fmt.Sprintf("[%s] %d/%d %d%% | %.1f/s | ETA %s",
+		bar, done, total, percent, rate, formatETA(eta, rate))
+	if p.failed > 0 {
+		status += fmt.Sprintf(" | %d failed", p.failed)
+	}
+
+	fmt.Fprintf(p.out, "\r\033[K%s", status)
+	p.drawn = true
+}
+
+// line prints a progress line. Callers hold the lock.
+func (p *Progress) line() {
+	_, _, rate, eta := p.stats()
+
+	fmt.Fprintf(p.out,
+		"progress discovered=%d fetched=%d embedded=%d skipped=%d failed=%d rate=%.1f eta=%s elapsed=%s\n",
+		p.discovered, p.fetched, p.embedded, p.skipped, p.failed,
+		rate, formatETA(eta, rate), time.Since(p.start).Round(time.Second))
+}
+
+// clear removes the progress bar from the screen. Callers hold the
+// lock.
+func (p *Progress) clear() {
+	if p.drawn {
+		fmt.Fprint(p.out, "\r\033[K")
+		p.drawn = false
+	}
+}
+
+func formatETA(eta time.Duration, rate float64) string {
+	if rate == 0 {
+		return "-"
+	}
+
+	return eta.Round(time.Second).String()
+}
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// minMainContentLength is the minimum amount of text the detected
+	// main content should have. Below this the whole body is used.
+	minMainContentLength = 200
+	// maxLinkDensity is the fraction of text within links above which
+	// a block is considered navigation
+	maxLinkDensity = 0.5
+)
+
+// boilerplateTags are elements that never contain the main content
+var boilerplateTags = map[string]bool{
+	"script":   true,
+	"style":    true,
+	"noscript": true,
+	"nav":      true,
+	"header":   true,
+	"footer":   true,
+	"aside":    true,
+	"iframe":   true,
+	"svg":      true,
+	"button":   true,
+	"template": true,
+}
+
+// boilerplateRoles are ARIA roles used for page chrome
+var boilerplateRoles = map[string]bool{
+	"navigation":    true,
+	"banner":        true,
+	"contentinfo":   true,
+	"complementary": true,
+	"search":        true,
+	"dialog":        true,
+	"alertdialog":   true,
+}
+
+// boilerplatePattern matches class names and ids commonly used for
+// page chrome like cookie banners, menus and share widgets
+var boilerplatePattern = regexp.MustCompile(
+	`(?i)(^|[\s_-])(cookie|consent|gdpr|banner|navbar|menu|breadcrumbs?|sidebar|footer|share|social|related|advert|ads|promo|popup|modal|newsletter|subscribe|comments?)($|[\s_-])`)
+
+// linkDensityTags are the blocks that are dropped if they are mostly
+// made up of links
+var linkDensityTags = map[string]bool{
+	"div":     true,
+	"section": true,
+	"ul":      true,
+	"ol":      true,
+	"table":   true,
+}
+
+// extractMainContent strips boilerplate from an HTML page and returns
+// the HTML of the main content along with metadata from the head
+func extractMainContent(body []byte) (string, map[string]string) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return string(body), nil
+	}
+
+	metadata := extractPageMetadata(doc)
+
+	removeBoilerplate(doc, false)
+
+	main := findMainNode(doc)
+	if main == nil || len(nodeText(main)) < minMainContentLength {
+		main = findElement(doc, "body")
+		if main == nil {
+			main = doc
+		}
+
+		// Without a main node the whole body is used, drop the menus
+		// and link lists around the content that were not caught by
+		// their markup. A detected main node is kept as is, as its
+		// lists and tables are part of the content.
+		pruneLinkDense(main)
+	}
+
+	var out bytes.Buffer
+	if err := html.Render(&out, main); err != nil {
+		return string(body), metadata
+	}
+
+	return out.String(), metadata
+}
+
+// extractPageMetadata reads the description, canonical URL, author and
+// published date from the page
+func extractPageMetadata(doc *html.Node) map[string]string {
+	metadata := map[string]string{}
+	set := func(key, value string) {
+		value = strings.TrimSpace(value)
+		if value != "" && metadata[key] == "" {
+			metadata[key] = value
+		}
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "meta":
+				name := strings.ToLower(attr(n, "name") + attr(n, "property") + attr(n, "itemprop"))
+				content := attr(n, "content")
+				switch name {
+				case "description", "og:description", "twitter:description":
+					set("description", content)
+				case "author", "article:author", "dc.creator":
+					set("author", content)
+				case "article:published_time", "datepublished", "date", "dc.date", "pubdate":
+					set("published", content)
+				}
+			case "link":
+				if strings.EqualFold(attr(n, "rel"), "canonical") {
+					set("canonical_url", attr(n, "href"))
+				}
+			case "time":
+				if attr(n, "itemprop") == "datePublished" || attr(n, "pubdate") != "" {
+					set("published", attr(n, "datetime"))
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return metadata
+}
+
+// removeBoilerplate removes elements that are page chrome rather than
+// content. inContent is set within elements that can hold the main
+// content.
+func removeBoilerplate(n *html.Node, inContent bool) {
+	inContent = inContent || isContentNode(n)
+
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		if c.Type == html.CommentNode || (c.Type == html.ElementNode && isBoilerplate(c, inContent)) {
+			n.RemoveChild(c)
+		} else {
+			removeBoilerplate(c, inContent)
+		}
+
+		c = next
+	}
+}
+
+func isBoilerplate(n *html.Node, inContent bool) bool {
+	switch n.Data {
+	case "html", "body", "main", "article":
+		return false
+	}
+
+	// Articles keep their title and byline in a header, only the page
+	// header is chrome
+	if n.Data == "header" && inContent {
+		return false
+	}
+
+	if boilerplateTags[n.Data] {
+		return true
+	}
+
+	// Search boxes and sign up forms are chrome, but ASP.NET and many
+	// intranet pages wrap their whole body in a single form
+	if n.Data == "form" {
+		return findElement(n, "main") == nil && findElement(n, "article") == nil &&
+			len(nodeText(n)) < minMainContentLength
+	}
+
+	if boilerplateRoles[strings.ToLower(attr(n, "role"))] {
+		return true
+	}
+
+	if strings.EqualFold(attr(n, "aria-hidden"), "true") {
+		return true
+	}
+
+	if !boilerplatePattern.MatchString(attr(n, "class")) &&
+		!boilerplatePattern.MatchString(attr(n, "id")) {
+		return false
+	}
+
+	// Layout wrappers sometimes carry names like "with-sidebar", do not
+	// drop them if the content is nested within
+	return findElement(n, "main") == nil && findElement(n, "article") == nil
+}
+
+// isContentNode checks if an element can hold the main content
+func isContentNode(n *html.Node) bool {
+	return n.Type == html.ElementNode &&
+		(n.Data == "main" || n.Data == "article" || strings.EqualFold(attr(n, "role"), "main"))
+}
+
+// findMainNode finds the element holding the main content. <main> and
+// role="main" are preferred, followed by the largest <article>.
+func findMainNode(doc *html.Node) *html.Node {
+	var main, article *html.Node
+	articleLength := 0
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if main == nil && (n.Data == "main" || strings.EqualFold(attr(n, "role"), "main")) {
+				main = n
+			}
+
+			if n.Data == "article" {
+				if length := len(nodeText(n)); length > articleLength {
+					article = n
+					articleLength = length
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	if main != nil && len(nodeText(main)) >= minMainContentLength {
+		return main
+	}
+
+	return article
+}
+
+// pruneLinkDense removes blocks that are mostly links, like menus and
+// lists of related pages that were not caught by their markup. Elements
+// that can hold the main content are not pruned.
+func pruneLinkDense(n *html.Node) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+
+		if isContentNode(c) {
+			c = next
+			continue
+		}
+
+		if c.Type == html.ElementNode && linkDensityTags[c.Data] {
+			text := len(strings.TrimSpace(nodeText(c)))
+			if text > 0 && float64(linkTextLength(c))/float64(text) > maxLinkDensity {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+		}
+
+		pruneLinkDense(c)
+		c = next
+	}
+}
+
+// nodeText returns the text content of a node
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// linkTextLength returns the length of the text within links in a node
+func linkTextLength(n *html.Node) int {
+	if n.Type == html.ElementNode && n.Data == "a" {
+		return len(strings.TrimSpace(nodeText(n)))
+	}
+
+	total := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		total += linkTextLength(c)
+	}
+	return total
+}
+
+// findElement returns the first element with the given tag
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, tag); found != nil {
+			return found
+		}
+	}
+
+	return nil
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
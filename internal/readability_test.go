@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExtractMainContent(t *testing.T) {
+	paragraph := "<p>" + strings.Repeat("The quarterly report covers revenue and hiring. ", 6) + "</p>"
+
+	tests := []struct {
+		name    string
+		page    string
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "body wrapped in a form",
+			page: `<html><body><form id="form1" method="post" action="./Default.aspx">
+<input type="hidden" name="__VIEWSTATE" value="x">
+<div class="content"><h1>Quarterly report</h1>` + paragraph + `</div>
+</form></body></html>`,
+			want: []string{"Quarterly report", "quarterly report covers revenue"},
+		},
+		{
+			name: "search form next to the content",
+			page: `<html><body>
+<form role="presentation" action="/search"><input name="q"><label>Search the site</label></form>
+<main><h1>Quarterly report</h1>` + paragraph + `</main>
+</body></html>`,
+			want:    []string{"Quarterly report"},
+			notWant: []string{"Search the site"},
+		},
+		{
+			name: "article header is kept",
+			page: `<html><body><header>Site name</header>
+<article><header><h1>Quarterly report</h1><p>By Jane</p></header>` + paragraph + `</article>
+</body></html>`,
+			want:    []string{"Quarterly report", "By Jane"},
+			notWant: []string{"Site name"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := extractMainContent([]byte(tt.page))
+
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("extractMainContent() = %q, want it to contain %q", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("extractMainContent() = %q, want it to not contain %q", got, notWant)
+				}
+			}
+		})
+	}
+}
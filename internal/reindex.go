@@ -0,0 +1,460 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	// reindexSuffix is appended to the database path to get the path of
+	// the database being built by reindex. It is next to the database so
+	// that it can be renamed over it and is kept if reindex is
+	// interrupted so that the next run can resume.
+	reindexSuffix = ".reindex"
+	// reindexLockSuffix is appended to the database path to get the path
+	// of the lock file which prevents concurrent reindex runs
+	reindexLockSuffix = ".reindex.lock"
+)
+
+// ReindexOptions configures a reindex
+type ReindexOptions struct {
+	// EmbeddingSize is the size of the embeddings of the current model
+	EmbeddingSize int
+	// MaxWorkers is the number of documents processed in parallel
+	MaxWorkers int
+	// NoBackup removes the previous database instead of keeping it
+	NoBackup bool
+}
+
+// ReindexResult summarizes a reindex
+type ReindexResult struct {
+	// Original is the number of documents in the database
+	Original int
+	// Resumed is the number of documents done by an earlier run which
+	// was interrupted
+	Resumed int
+	// Changed is the number of documents that were embedded again
+	Changed int
+	// Missing is the number of documents that could not be fetched and
+	// were dropped
+	Missing int
+	// Backup is the path the previous database was moved to
+	Backup string
+	// Errors are the documents that failed. The database is not
+	// replaced if there are any.
+	Errors []error
+}
+
+// Reindex rebuilds the database at dbPath, embedding documents again if
+// they have changed or if the embedding model is different. The new
+// database is built next to the old one and renamed over it once it is
+// complete, so the old database stays intact if reindex fails or is
+// interrupted. Progress is committed as it goes and an interrupted
+// reindex continues where it stopped on the next run.
+//
+// db is the open database at dbPath, it is closed before it is replaced.
+func Reindex(ctx context.Context, db *sql.DB, dbPath string, opts ReindexOptions) (*ReindexResult, error) {
+	if opts.MaxWorkers <= 0 {
+		opts.MaxWorkers = maxParallelEmbeddingRequests
+	}
+
+	lock, err := os.OpenFile(dbPath+reindexLockSuffix, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("create lock file: %w", err)
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock); err != nil {
+		return nil, fmt.Errorf("lock %s: %w", dbPath, err)
+	}
+
+	tempPath := dbPath + reindexSuffix
+	tempDB, resumed, err := openReindexDatabase(tempPath, opts.EmbeddingSize)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDatabase(tempDB)
+
+	config, err := GetConfig(db)
+	if err != nil {
+		return nil, err
+	}
+	modelChanged := config["embedding_model"] != Model ||
+		config["embedding_size"] != fmt.Sprintf("%d", opts.EmbeddingSize)
+
+	docs, err := GetAllDocuments(db)
+	if err != nil {
+		return nil, err
+	}
+
+	done := map[string]bool{}
+	if resumed {
+		done, err = resumeReindexDatabase(tempDB, docs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ReindexResult{Original: len(docs)}
+	progress := progressFrom(ctx)
+	progress.Discovered(len(docs) - len(done))
+
+	var (
+		wg         sync.WaitGroup
+		resultLock sync.Mutex
+		docChan    = make(chan Document)
+	)
+	for i := 0; i < opts.MaxWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for doc := range docChan {
+				// Stop picking up documents once cancelled, the ones
+				// that were started are finished and saved so that the
+				// next run does not have to redo them
+				if ctx.Err() != nil {
+					continue
+				}
+
+				changed, err := reindexDocument(context.WithoutCancel(ctx), db, tempDB, doc, modelChanged)
+
+				resultLock.Lock()
+				switch {
+				case errors.Is(err, errDocumentMissing):
+					progress.Skipped()
+					progress.Printf("Ignoring missing document: %s\n", doc.Path)
+					result.Missing++
+				case err != nil:
+					progress.Failed()
+					result.Errors = append(result.Errors, fmt.Errorf("%s: %w", doc.Path, err))
+				case changed:
+					progress.Embedded()
+					result.Changed++
+				default:
+					progress.Skipped()
+				}
+				resultLock.Unlock()
+			}
+		}()
+	}
+
+	for _, doc := range docs {
+		if done[doc.Path] {
+			result.Resumed++
+			continue
+		}
+		docChan <- doc
+	}
+	close(docChan)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return result, fmt.Errorf(
+			"reindex interrupted, the database was not changed and the next reindex will resume from %s",
+			tempPath)
+	}
+
+	if len(result.Errors) > 0 {
+		return result, fmt.Errorf(
+			"%d documents failed, the database was not changed and the next reindex will resume from %s",
+			len(result.Errors), tempPath)
+	}
+
+	if err := CopyCrawlRoots(db, tempDB); err != nil {
+		return result, err
+	}
+
+	if err := CopyFeeds(db, tempDB); err != nil {
+		return result, err
+	}
+
+	if err := finishReindexDatabase(tempDB, tempPath); err != nil {
+		return result, err
+	}
+
+	// Close the database so that its write-ahead log is checkpointed and
+	// removed, it would otherwise be applied to the new database
+	if err := closeDatabase(db); err != nil {
+		return result, fmt.Errorf("close database: %w", err)
+	}
+	if fileExists(dbPath + "-wal") {
+		return result, fmt.Errorf("database %s is in use by another process", dbPath)
+	}
+
+	backup, err := swapDatabase(tempPath, dbPath, !opts.NoBackup)
+	if err != nil {
+		return result, err
+	}
+	result.Backup = backup
+
+	os.Remove(lock.Name())
+
+	return result, nil
+}
+
+// errDocumentMissing is returned for documents that do not exist
+// anymore
+var errDocumentMissing = errors.New("document missing")
+
+// isDocumentGone checks if fetching a document failed because it does
+// not exist anymore rather than for a reason that may be temporary
+func isDocumentGone(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone
+	}
+
+	return errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, errFeedEntryGone) ||
+		errors.Is(err, git.ErrRepositoryNotExists) ||
+		errors.Is(err, plumbing.ErrObjectNotFound) ||
+		errors.Is(err, plumbing.ErrReferenceNotFound) ||
+		errors.Is(err, object.ErrFileNotFound)
+}
+
+// reindexDocument copies a document to the new database, embedding it
+// again if it has changed or if the model is different. It returns
+// whether the document was embedded again.
+func reindexDocument(ctx context.Context, db, tempDB *sql.DB, doc Document, modelChanged bool) (bool, error) {
+	var err error
+	doc.Metadata, err = GetDocumentMetadata(db, doc.Path)
+	if err != nil {
+		return false, err
+	}
+
+	doc.Links, err = getDocumentOutgoingLinks(db, doc.Path)
+	if err != nil {
+		return false, err
+	}
+
+	newDoc, err := FetchDocumentIfModified(doc.Path, &doc)
+	switch {
+	case errors.Is(err, ErrNotModified):
+		newDoc = &doc
+	case isDocumentGone(err):
+		return false, errDocumentMissing
+	case err != nil:
+		// The failure may be temporary, like a timeout or a command
+		// that failed, keep the previous version rather than dropping
+		// the document. It cannot be kept if it has to be embedded
+		// again with another model.
+		if modelChanged {
+			return false, fmt.Errorf("fetch document: %w", err)
+		}
+		progressFrom(ctx).Printf("Keeping previous version of %s: %v\n", doc.Path, err)
+		newDoc = &doc
+	default:
+		progressFrom(ctx).Fetched()
+		keepSourceMetadata(newDoc, &doc)
+	}
+
+	if !modelChanged && newDoc.Content == doc.Content {
+		chunks, err := GetDocumentChunks(db, doc.ID)
+		if err != nil {
+			return false, err
+		}
+
+		return false, UpdateDocument(tempDB, newDoc, chunks)
+	}
+
+	chunks, err := EmbedDocument(ctx, newDoc)
+	if err != nil {
+		return false, err
+	}
+
+	return true, UpdateDocument(tempDB, newDoc, chunks)
+}
+
+// openReindexDatabase opens the database being built by reindex. A
+// database left by an interrupted reindex is reused if it was built with
+// the same embedding model, otherwise it is started over.
+func openReindexDatabase(path string, embeddingSize int) (*sql.DB, bool, error) {
+	if fileExists(path) {
+		db, _, err := CreateDB(path)
+		if err != nil {
+			return nil, false, err
+		}
+
+		config, err := GetConfig(db)
+		if err == nil && config["embedding_model"] == Model &&
+			config["embedding_size"] == fmt.Sprintf("%d", embeddingSize) {
+			if _, _, err := Migrate(db); err == nil {
+				return db, true, nil
+			}
+		}
+
+		closeDatabase(db)
+		if err := removeDatabaseFiles(path); err != nil {
+			return nil, false, err
+		}
+	}
+
+	db, _, err := CreateDB(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := InitDatabase(db, embeddingSize); err != nil {
+		closeDatabase(db)
+		return nil, false, fmt.Errorf("initialize database: %w", err)
+	}
+
+	err = SaveConfig(db, map[string]string{
+		"embedding_model": Model,
+		"embedding_size":  fmt.Sprintf("%d", embeddingSize),
+		indexRootKey:      IndexRoot,
+	})
+	if err != nil {
+		closeDatabase(db)
+		return nil, false, err
+	}
+
+	return db, false, nil
+}
+
+// resumeReindexDatabase returns the paths of the documents an earlier
+// run already copied to the reindex database. Documents that were
+// removed from the database since then are dropped so that they do not
+// come back once the reindex is done.
+func resumeReindexDatabase(tempDB *sql.DB, docs []Document) (map[string]bool, error) {
+	paths, err := GetAllFilePaths(tempDB)
+	if err != nil {
+		return nil, err
+	}
+
+	current := map[string]bool{}
+	for _, doc := range docs {
+		current[doc.Path] = true
+	}
+
+	done := map[string]bool{}
+	for _, path := range paths {
+		if current[path] {
+			done[path] = true
+			continue
+		}
+
+		err := writerFor(tempDB).write(func(tx *sql.Tx) error {
+			var id int64
+			if err := tx.QueryRow("SELECT id FROM documents WHERE filepath = ?", path).Scan(&id); err != nil {
+				return fmt.Errorf("query document: %w", err)
+			}
+			return deleteDocument(tx, id, path)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("remove %s from reindex database: %w", path, err)
+		}
+	}
+
+	return done, nil
+}
+
+// finishReindexDatabase writes everything in the write-ahead log to the
+// database file, closes it and makes sure it is on disk
+func finishReindexDatabase(db *sql.DB, path string) error {
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return fmt.Errorf("checkpoint database: %w", err)
+	}
+
+	if err := closeDatabase(db); err != nil {
+		return fmt.Errorf("close database: %w", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync database: %w", err)
+	}
+
+	return nil
+}
+
+// swapDatabase atomically replaces the database at path with the one at
+// newPath. If backup is set, the previous database is kept and the path
+// of the backup is returned.
+func swapDatabase(newPath, path string, backup bool) (string, error) {
+	var backupPath string
+	if backup {
+		backupPath = databaseBackupPath(path)
+		if fileExists(backupPath) {
+			return "", fmt.Errorf("backup %s already exists", backupPath)
+		}
+
+		// A hard link keeps the previous database without copying it
+		// and without there being a moment where path does not exist
+		if err := os.Link(path, backupPath); err != nil {
+			if err := copyFile(path, backupPath); err != nil {
+				return "", fmt.Errorf("backup database to %s: %w", backupPath, err)
+			}
+		}
+	}
+
+	if err := os.Rename(newPath, path); err != nil {
+		return "", fmt.Errorf("replace database: %w", err)
+	}
+
+	// Make sure the rename is on disk. Not every platform supports
+	// syncing directories, so errors are ignored.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	return backupPath, nil
+}
+
+// copyFile copies a file and syncs the copy to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
+// removeDatabaseFiles removes a database along with its write-ahead log
+// and shared memory files
+func removeDatabaseFiles(path string) error {
+	for _, name := range []string{path, path + "-wal", path + "-shm"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReindexKeepsDocumentsThatFailTemporarily(t *testing.T) {
+	var failing atomic.Bool
+	mux := http.NewServeMux()
+	for _, page := range []string{"/unavailable", "/removed", "/current"} {
+		mux.HandleFunc(page, func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case failing.Load() && r.URL.Path == "/unavailable":
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case failing.Load() && r.URL.Path == "/removed":
+				w.WriteHeader(http.StatusNotFound)
+			default:
+				w.Header().Set("Content-Type", "text/plain")
+				fmt.Fprintf(w, "The page at %s. %s", r.URL.Path, strings.Repeat("Some text. ", 10))
+			}
+		})
+	}
+
+	db, dbPath, serverURL := newTestDatabase(t, mux)
+	ctx := context.Background()
+
+	for _, page := range []string{"/unavailable", "/removed", "/current"} {
+		if err := AddDocument(ctx, db, serverURL+page); err != nil {
+			t.Fatalf("AddDocument(%s): %v", page, err)
+		}
+	}
+
+	failing.Store(true)
+	result, err := Reindex(ctx, db, dbPath, ReindexOptions{EmbeddingSize: 2, NoBackup: true})
+	if err != nil {
+		t.Fatalf("Reindex: %v", err)
+	}
+	if result.Missing != 1 {
+		t.Errorf("Reindex() found %d missing documents, want 1", result.Missing)
+	}
+
+	reindexed, _, err := CreateDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reindexed.Close()
+
+	paths, err := GetAllFilePaths(reindexed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, page := range []string{"/unavailable", "/current"} {
+		if !slices.Contains(paths, serverURL+page) {
+			t.Errorf("%s was dropped by reindex, documents are %v", page, paths)
+		}
+	}
+	if slices.Contains(paths, serverURL+"/removed") {
+		t.Errorf("/removed was kept by reindex, documents are %v", paths)
+	}
+}
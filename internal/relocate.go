@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// relocateBoundaries are the characters that can follow a directory in a
+// document path: a path separator, the separators of files within
+// archives and messages within mbox files, and the revision separator of
+// git documents
+var relocateBoundaries = string(filepath.Separator) + "!#@"
+
+// RelocateResult summarizes a relocate
+type RelocateResult struct {
+	// Root is the new index root if it changed
+	Root string
+	// Documents is the number of documents whose path changed
+	Documents int
+}
+
+// Relocate updates the index after its files have been moved from one
+// directory to another. The index root is moved if it is within from,
+// which relocates every document stored relative to it. Absolute paths
+// within from are rewritten, including the repository paths of git
+// documents and the targets of links.
+func Relocate(db *sql.DB, from, to string) (*RelocateResult, error) {
+	from, err := filepath.Abs(from)
+	if err != nil {
+		return nil, fmt.Errorf("resolve path %s: %w", from, err)
+	}
+
+	to, err = filepath.Abs(to)
+	if err != nil {
+		return nil, fmt.Errorf("resolve path %s: %w", to, err)
+	}
+
+	relocate := func(path string) string {
+		return relocatePath(path, from, to)
+	}
+
+	result := &RelocateResult{}
+	err = writerFor(db).write(func(tx *sql.Tx) error {
+		if root := relocatePath(IndexRoot, from, to); root != IndexRoot {
+			if _, err := tx.Exec(
+				"INSERT OR REPLACE INTO config (key, value) VALUES (?, ?)",
+				indexRootKey, root); err != nil {
+				return fmt.Errorf("save index root: %w", err)
+			}
+			result.Root = root
+		}
+
+		renamed, err := rewriteColumn(tx, "SELECT filepath FROM documents", relocate)
+		if err != nil {
+			return err
+		}
+
+		for old, path := range renamed {
+			for _, query := range []string{
+				"UPDATE documents SET title = ?1 WHERE filepath = ?2 AND title = ?2",
+				"UPDATE documents SET filepath = ? WHERE filepath = ?",
+				"UPDATE document_metadata SET filepath = ? WHERE filepath = ?",
+				"UPDATE OR REPLACE document_links SET source = ? WHERE source = ?",
+			} {
+				if _, err := tx.Exec(query, path, old); err != nil {
+					return fmt.Errorf("relocate %s: %w", old, err)
+				}
+			}
+		}
+		result.Documents = len(renamed)
+
+		targets, err := rewriteColumn(tx, "SELECT DISTINCT target FROM document_links", relocate)
+		if err != nil {
+			return err
+		}
+
+		for old, target := range targets {
+			if _, err := tx.Exec(
+				"UPDATE OR REPLACE document_links SET target = ? WHERE target = ?",
+				target, old); err != nil {
+				return fmt.Errorf("relocate link %s: %w", old, err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Root != "" {
+		IndexRoot = result.Root
+	}
+
+	return result, nil
+}
+
+// rewriteColumn returns the values returned by the query that are
+// changed by rewrite, mapped to their new value
+func rewriteColumn(tx *sql.Tx, query string, rewrite func(string) string) (map[string]string, error) {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("query paths: %w", err)
+	}
+	defer rows.Close()
+
+	renamed := map[string]string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, fmt.Errorf("scan path: %w", err)
+		}
+
+		if rewritten := rewrite(path); rewritten != path {
+			renamed[path] = rewritten
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating paths: %w", err)
+	}
+
+	return renamed, nil
+}
+
+// relocatePath replaces from with to at the start of an absolute path,
+// or of the repository path of a git document
+func relocatePath(path, from, to string) string {
+	for _, prefix := range []string{gitLogPrefix, gitTreePrefix, ""} {
+		rest, ok := strings.CutPrefix(path, prefix)
+		if !ok || !strings.HasPrefix(rest, from) {
+			continue
+		}
+
+		tail := rest[len(from):]
+		if tail == "" || strings.ContainsRune(relocateBoundaries, rune(tail[0])) {
+			return prefix + to + tail
+		}
+	}
+
+	return path
+}
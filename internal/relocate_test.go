@@ -0,0 +1,32 @@
+package internal
+
+import "testing"
+
+func TestRelocatePath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"file", "/old/notes/todo.md", "/new/notes/todo.md"},
+		{"directory itself", "/old/notes", "/new/notes"},
+		{"sibling with same prefix", "/old/notes-archive/todo.md", "/old/notes-archive/todo.md"},
+		{"archive entry", "/old/notes!/docs/readme.md", "/new/notes!/docs/readme.md"},
+		{"mbox message", "/old/notes#12", "/new/notes#12"},
+		{"git revision", "/old/notes@v1.0:README.md", "/new/notes@v1.0:README.md"},
+		{"git log", "gitlog:///old/notes@0123456789abcdef0123456789abcdef01234567", "gitlog:///new/notes@0123456789abcdef0123456789abcdef01234567"},
+		{"git tree", "git:///old/notes@main:docs/index.md", "git:///new/notes@main:docs/index.md"},
+		{"git tree of other repository", "git:///old/notes2@main:a.md", "git:///old/notes2@main:a.md"},
+		{"relative path", "./old/notes/todo.md", "./old/notes/todo.md"},
+		{"remote", "https://example.com/old/notes", "https://example.com/old/notes"},
+		{"outside", "/other/old/notes/todo.md", "/other/old/notes/todo.md"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relocatePath(tt.path, "/old/notes", "/new/notes"); got != tt.want {
+				t.Errorf("relocatePath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
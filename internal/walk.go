@@ -0,0 +1,390 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+)
+
+const (
+	// referignoreFile holds gitignore style patterns for files that
+	// should not be indexed. It is read in every directory.
+	referignoreFile = ".referignore"
+	gitignoreFile   = ".gitignore"
+)
+
+// defaultExcludes are files that are rarely useful to search, like
+// lockfiles, minified or generated code and vendored dependencies
+var defaultExcludes = []string{
+	// Lockfiles
+	"package-lock.json",
+	"npm-shrinkwrap.json",
+	"yarn.lock",
+	"pnpm-lock.yaml",
+	"bun.lockb",
+	"Cargo.lock",
+	"go.sum",
+	"poetry.lock",
+	"Pipfile.lock",
+	"uv.lock",
+	"composer.lock",
+	"Gemfile.lock",
+	"flake.lock",
+	"mix.lock",
+	"pubspec.lock",
+	"Podfile.lock",
+
+	// Minified and generated code
+	"*.min.js",
+	"*.min.css",
+	"*.map",
+	"*.pb.go",
+	"*.pb.cc",
+	"*.pb.h",
+	"*_pb2.py",
+	"*_pb2_grpc.py",
+
+	// Vendored dependencies
+	"node_modules/",
+	"vendor/",
+	"bower_components/",
+	".venv/",
+
+	// Version control
+	".git/",
+	".jj/",
+	".hg/",
+	".svn/",
+}
+
+// WalkOptions configures which files are added from a directory
+type WalkOptions struct {
+	// NoIgnore disables gitignore rules. .referignore files are still
+	// honored.
+	NoIgnore bool
+	// NoDefaultExcludes adds files matched by defaultExcludes
+	NoDefaultExcludes bool
+	// Include limits the files to the ones matching these patterns
+	Include []string
+	// Exclude skips files matching these patterns
+	Exclude []string
+	// MaxSize skips files larger than this many bytes if set
+	MaxSize int64
+	// FollowSymlinks descends into symlinked directories. Symlinked
+	// files are always added.
+	FollowSymlinks bool
+	// SkipHidden skips files and directories starting with a dot
+	SkipHidden bool
+	// OneFileSystem skips directories on a different filesystem than
+	// the one being added, like mounted network volumes
+	OneFileSystem bool
+	// MaxDepth limits how many levels of directories are descended
+	// into if set. 1 only adds the files directly in the directory.
+	MaxDepth int
+}
+
+// walker holds the ignore rules collected while walking a directory.
+// Patterns are relative to base, which is the root of the git repository
+// if there is one so that gitignore rules apply as they do in git.
+type walker struct {
+	opts      WalkOptions
+	root      string
+	base      string
+	rootParts []string
+
+	patterns []gitignore.Pattern
+	excludes []gitignore.Pattern
+	includes []gitignore.Pattern
+	matcher  gitignore.Matcher
+
+	// rootDevice is the device of the root when OneFileSystem is set
+	rootDevice uint64
+	// visited holds the resolved paths of the directories walked so
+	// that symlink cycles are not followed
+	visited map[string]bool
+
+	paths  []string
+	errors []error
+}
+
+// WalkPath returns the paths of the documents under a file or
+// directory, skipping files excluded by the options, gitignore rules
+// and .referignore files. Files given directly are always included.
+func WalkPath(root string, opts WalkOptions) ([]string, []error) {
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, []error{fmt.Errorf("walk %s: %w", root, err)}
+	}
+
+	// Walked paths are always files, even if their name looks like a
+	// command or a URL
+	root = localPath(root)
+
+	if !info.IsDir() {
+		paths, err := ExpandPath(root, opts.MaxSize)
+		if err != nil {
+			return nil, []error{fmt.Errorf("read %s: %w", root, err)}
+		}
+		return paths, nil
+	}
+
+	w, err := newWalker(root, opts)
+	if err != nil {
+		return nil, []error{err}
+	}
+
+	if opts.OneFileSystem {
+		device, ok := deviceID(info)
+		if !ok {
+			return nil, []error{fmt.Errorf("staying on one filesystem is not supported on this platform")}
+		}
+		w.rootDevice = device
+	}
+
+	w.walkDir(root, w.rootParts, 0)
+
+	return w.paths, w.errors
+}
+
+// walkDir adds the files in a directory and descends into its
+// subdirectories
+func (w *walker) walkDir(dir string, parts []string, depth int) {
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		w.errors = append(w.errors, fmt.Errorf("walk %s: %w", dir, err))
+		return
+	}
+	if w.visited[resolved] {
+		return
+	}
+	w.visited[resolved] = true
+
+	if err := w.loadIgnoreFiles(dir, parts); err != nil {
+		w.errors = append(w.errors, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		w.errors = append(w.errors, fmt.Errorf("walk %s: %w", dir, err))
+		return
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dir, name)
+		entryParts := append(slices.Clip(parts), name)
+
+		if w.opts.SkipHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			w.errors = append(w.errors, fmt.Errorf("walk %s: %w", path, err))
+			continue
+		}
+
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Stat(path)
+			if err != nil {
+				w.errors = append(w.errors, fmt.Errorf("broken symlink %s: %w", path, err))
+				continue
+			}
+			if target.IsDir() && !w.opts.FollowSymlinks {
+				continue
+			}
+			info = target
+		}
+
+		if w.ignored(entryParts, info) {
+			continue
+		}
+
+		if info.IsDir() {
+			if w.opts.MaxDepth > 0 && depth+1 >= w.opts.MaxDepth {
+				continue
+			}
+
+			if w.opts.OneFileSystem {
+				if device, ok := deviceID(info); ok && device != w.rootDevice {
+					continue
+				}
+			}
+
+			w.walkDir(path, entryParts, depth+1)
+			continue
+		}
+
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		expanded, err := ExpandPath(localPath(path), w.opts.MaxSize)
+		if err != nil {
+			w.errors = append(w.errors, fmt.Errorf("read %s: %w", path, err))
+			continue
+		}
+		w.paths = append(w.paths, expanded...)
+	}
+}
+
+func newWalker(root string, opts WalkOptions) (*walker, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", root, err)
+	}
+
+	w := &walker{opts: opts, root: root, base: absRoot, visited: map[string]bool{}}
+
+	if !opts.NoDefaultExcludes {
+		for _, pattern := range defaultExcludes {
+			w.patterns = append(w.patterns, gitignore.ParsePattern(pattern, nil))
+		}
+	}
+
+	if !opts.NoIgnore {
+		if gitDir, err := FindGitDir(absRoot); err == nil {
+			w.base = gitDir
+
+			patterns, err := LoadGitignorePatterns(gitDir)
+			if err != nil {
+				return nil, fmt.Errorf("load gitignore patterns: %w", err)
+			}
+			w.patterns = append(w.patterns, patterns...)
+		}
+	}
+
+	rel, err := filepath.Rel(w.base, absRoot)
+	if err != nil {
+		return nil, fmt.Errorf("resolve %s: %w", root, err)
+	}
+	if rel != "." {
+		w.rootParts = strings.Split(rel, string(filepath.Separator))
+	}
+
+	// Ignore files between the repository root and the directory being
+	// added apply as well
+	for i := range w.rootParts {
+		dir := filepath.Join(append([]string{w.base}, w.rootParts[:i]...)...)
+		if err := w.loadIgnoreFiles(dir, w.rootParts[:i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Include and exclude patterns are relative to the directory being
+	// added rather than the repository
+	for _, pattern := range opts.Exclude {
+		w.excludes = append(w.excludes, gitignore.ParsePattern(pattern, w.rootParts))
+	}
+	for _, pattern := range opts.Include {
+		w.includes = append(w.includes, gitignore.ParsePattern(pattern, w.rootParts))
+	}
+
+	w.updateMatcher()
+
+	return w, nil
+}
+
+// ignored checks if a file or directory should be skipped
+func (w *walker) ignored(parts []string, info fs.FileInfo) bool {
+	if w.matcher.Match(parts, info.IsDir()) {
+		return true
+	}
+
+	if info.IsDir() {
+		return false
+	}
+
+	if len(w.includes) > 0 {
+		included := false
+		for _, pattern := range w.includes {
+			if pattern.Match(parts, false) == gitignore.Exclude {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return true
+		}
+	}
+
+	return w.opts.MaxSize > 0 && info.Size() > w.opts.MaxSize
+}
+
+// loadIgnoreFiles reads the .gitignore and .referignore files in a
+// directory. .referignore is read last so that it can override
+// gitignore rules.
+func (w *walker) loadIgnoreFiles(dir string, parts []string) error {
+	files := []string{referignoreFile}
+	if !w.opts.NoIgnore {
+		files = []string{gitignoreFile, referignoreFile}
+	}
+
+	loaded := false
+	for _, name := range files {
+		patterns, err := LoadGitignoreFromFile(filepath.Join(dir, name), parts)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		w.patterns = append(w.patterns, patterns...)
+		loaded = true
+	}
+
+	if loaded {
+		w.updateMatcher()
+	}
+
+	return nil
+}
+
+// updateMatcher rebuilds the matcher with the exclude patterns last so
+// that they take precedence over ignore files
+func (w *walker) updateMatcher() {
+	patterns := make([]gitignore.Pattern, 0, len(w.patterns)+len(w.excludes))
+	patterns = append(patterns, w.patterns...)
+	patterns = append(patterns, w.excludes...)
+	w.matcher = gitignore.NewMatcher(patterns)
+}
+
+// ParseSize parses a size like "500K", "10MB" or "1GiB" into bytes.
+// Units are powers of 1024.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := s, ""
+	if i != -1 {
+		number, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	multiplier := map[string]float64{
+		"":  1,
+		"b": 1,
+		"k": 1 << 10, "kb": 1 << 10, "kib": 1 << 10,
+		"m": 1 << 20, "mb": 1 << 20, "mib": 1 << 20,
+		"g": 1 << 30, "gb": 1 << 30, "gib": 1 << 30,
+	}[strings.ToLower(unit)]
+	if multiplier == 0 {
+		return 0, fmt.Errorf("invalid size unit %q", unit)
+	}
+
+	return int64(value * multiplier), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package internal
+
+import "io/fs"
+
+// deviceID is not supported on this platform
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
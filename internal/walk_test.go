@@ -0,0 +1,44 @@
+package internal
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "0", want: 0},
+		{size: "512", want: 512},
+		{size: "512B", want: 512},
+		{size: "500K", want: 500 << 10},
+		{size: "10MB", want: 10 << 20},
+		{size: "1GiB", want: 1 << 30},
+		{size: "1.5m", want: 3 << 19},
+		{size: " 2 kb ", want: 2 << 10},
+		{size: "", wantErr: true},
+		{size: "MB", wantErr: true},
+		{size: "10TB", wantErr: true},
+		{size: "1.2.3K", wantErr: true},
+		{size: "-1K", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := ParseSize(tt.size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSize(%q) = %d, want an error", tt.size, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSize(%q): %v", tt.size, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
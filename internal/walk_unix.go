@@ -0,0 +1,18 @@
+//go:build unix
+
+package internal
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// deviceID returns the id of the device holding the file
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
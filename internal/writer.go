@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// maxWriteBatch is the largest number of writes committed in a single
+// transaction
+const maxWriteBatch = 64
+
+// writeRequest is a write waiting to be committed by the writer
+type writeRequest struct {
+	apply func(tx *sql.Tx) error
+	done  chan error
+}
+
+// writer funnels the writes to a database through a single goroutine.
+// SQLite only allows one writer at a time, so writing from the workers
+// directly makes them wait on each other and fail with "database is
+// locked" under load. Writes that are queued together are committed in
+// one transaction, which is much faster than a commit per document.
+type writer struct {
+	db       *sql.DB
+	requests chan writeRequest
+	// stopped is closed once the goroutine has committed every request
+	// and exited
+	stopped chan struct{}
+}
+
+// writers holds the writer of each database, they are created on first
+// use
+var writers sync.Map
+
+// writerFor returns the writer for a database, starting it if needed
+func writerFor(db *sql.DB) *writer {
+	if w, ok := writers.Load(db); ok {
+		return w.(*writer)
+	}
+
+	w, loaded := writers.LoadOrStore(db, &writer{
+		db:       db,
+		requests: make(chan writeRequest, maxWriteBatch),
+		stopped:  make(chan struct{}),
+	})
+	if !loaded {
+		go w.(*writer).run()
+	}
+
+	return w.(*writer)
+}
+
+// closeWriter stops the writer of a database after the queued writes
+// are committed and forgets it. It is called before the database is
+// closed, writing to the database afterwards starts a new writer.
+func closeWriter(db *sql.DB) {
+	w, ok := writers.LoadAndDelete(db)
+	if !ok {
+		return
+	}
+
+	close(w.(*writer).requests)
+	<-w.(*writer).stopped
+}
+
+// write queues fn to be run in a transaction and waits for it to be
+// committed. fn is run in a savepoint so that a failing write does not
+// affect the others in its batch.
+func (w *writer) write(fn func(tx *sql.Tx) error) error {
+	done := make(chan error, 1)
+	w.requests <- writeRequest{apply: fn, done: done}
+	return <-done
+}
+
+func (w *writer) run() {
+	defer close(w.stopped)
+
+	for request := range w.requests {
+		batch := []writeRequest{request}
+
+		// Pick up everything that was queued while the previous batch
+		// was being written without waiting for more
+	collect:
+		for len(batch) < maxWriteBatch {
+			select {
+			case request := <-w.requests:
+				batch = append(batch, request)
+			default:
+				break collect
+			}
+		}
+
+		w.commit(batch)
+	}
+}
+
+// commit runs a batch of writes in a single transaction and reports the
+// result to each of them
+func (w *writer) commit(batch []writeRequest) {
+	errs := make([]error, len(batch))
+
+	err := func() error {
+		tx, err := w.db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		defer tx.Rollback()
+
+		for i, request := range batch {
+			errs[i] = applyInSavepoint(tx, request.apply)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit transaction: %w", err)
+		}
+
+		return nil
+	}()
+
+	for i, request := range batch {
+		if err != nil {
+			request.done <- err
+		} else {
+			request.done <- errs[i]
+		}
+	}
+}
+
+// applyInSavepoint runs fn and undoes its changes if it fails
+func applyInSavepoint(tx *sql.Tx, fn func(tx *sql.Tx) error) error {
+	if _, err := tx.Exec("SAVEPOINT write"); err != nil {
+		return fmt.Errorf("create savepoint: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if _, rbErr := tx.Exec("ROLLBACK TO write"); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		if _, rbErr := tx.Exec("RELEASE write"); rbErr != nil {
+			return fmt.Errorf("%w (release savepoint failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	if _, err := tx.Exec("RELEASE write"); err != nil {
+		return fmt.Errorf("release savepoint: %w", err)
+	}
+
+	return nil
+}
@@ -1,35 +1,71 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
-	"io/fs"
 	"log"
+	"maps"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"slices"
-	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alecthomas/kong"
-	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/meain/refer/internal"
 )
 
 type CLI struct {
-	Database string   `help:"Database file path" default:".referdb"`
-	Add      Add      `cmd:"" help:"Add a file or directory to the database"`
-	Search   Search   `cmd:"" help:"Search for documents"`
-	Show     Show     `cmd:"" help:"List documents in the database"`
-	Stats    StatsCmd `cmd:"" help:"Show database statistics"`
-	Reindex  Reindex  `cmd:"" help:"Reindex all documents"`
-	Remove   Remove   `cmd:"" help:"Remove a document from the database"`
+	Database  string    `help:"Database file path" default:".referdb"`
+	Add       Add       `cmd:"" help:"Add a file or directory to the database"`
+	Search    Search    `cmd:"" help:"Search for documents"`
+	Show      Show      `cmd:"" help:"List documents in the database"`
+	Stats     StatsCmd  `cmd:"" help:"Show database statistics"`
+	Reindex   Reindex   `cmd:"" help:"Reindex all documents"`
+	Remove    Remove    `cmd:"" help:"Remove a document from the database"`
+	Links     Links     `cmd:"" help:"List documents linked from a document"`
+	Backlinks Backlinks `cmd:"" help:"List documents linking to a document"`
+	Recrawl   Recrawl   `cmd:"" help:"Crawl all previously crawled sites again"`
+	Feed      Feed      `cmd:"" help:"Manage RSS/Atom feed subscriptions"`
+	Migrate   Migrate   `cmd:"" help:"Upgrade the database schema to the current version"`
+	Export    Export    `cmd:"" help:"Export documents and embeddings as JSON lines"`
+	Import    Import    `cmd:"" help:"Import documents and embeddings exported with export"`
+	Relocate  Relocate  `cmd:"" help:"Update document paths after moving indexed files"`
 }
 
 type Add struct {
-	FilePath []string `arg:"" required:"" help:"File, directory or URL to add to the database"`
+	FilePath []string `arg:"" optional:"" help:"File, directory, mbox file, Maildir, archive, git://<repo>@<rev> or URL to add to the database"`
 	NoIgnore bool     `help:"Do not ignore files that are ignored by git"`
+	GitLog   bool     `help:"Index the commit history of the given git repositories instead of their files"`
+	GitDiff  bool     `help:"Include diff hunks when indexing commit history"`
+	Rev      string   `help:"Index the files of the given git repositories at this revision (commit, tag or branch) without checking it out"`
+
+	Include           []string `help:"Only add files matching these gitignore style patterns"`
+	Exclude           []string `help:"Do not add files matching these gitignore style patterns"`
+	MaxSize           string   `help:"Do not add files larger than this size (eg: 1MB)"`
+	NoDefaultExcludes bool     `help:"Add lockfiles, minified files and vendored dependencies which are skipped by default"`
+	FollowSymlinks    bool     `help:"Descend into symlinked directories"`
+	SkipHidden        bool     `help:"Skip files and directories starting with a dot"`
+	OneFileSystem     bool     `help:"Do not descend into directories on other filesystems"`
+	MaxDepth          int      `help:"Maximum number of directory levels to descend into (1 only adds files directly in the directory)"`
+
+	Bookmarks bool `help:"Treat the given files as bookmark exports (Netscape bookmark HTML) or URL lists and add the pages in them"`
+
+	Stdin bool     `help:"Add a document read from stdin"`
+	Name  string   `help:"Name of the document read from stdin (eg: notes/meeting.md)"`
+	Exec  []string `help:"Add the output of a shell command, the command is run again on reindex"`
+
+	Crawl            bool     `help:"Crawl the given URLs following links on the same origin"`
+	CrawlDepth       int      `default:"2" help:"Number of links to follow from the crawl root"`
+	CrawlSitemap     bool     `help:"Seed the crawl with the URLs in the site's sitemap.xml"`
+	CrawlInclude     []string `help:"Only crawl URLs matching these regular expressions"`
+	CrawlExclude     []string `help:"Do not crawl URLs matching these regular expressions"`
+	CrawlConcurrency int      `default:"5" help:"Number of pages to fetch in parallel when crawling"`
+	Progress         string   `enum:"auto,bar,lines,none" default:"auto" help:"Show progress as a bar, as periodic lines or not at all (auto picks bar on a terminal)"`
 }
 
 type Search struct {
@@ -38,9 +74,22 @@ type Search struct {
 	Limit     int      `default:"5" help:"Maximum number of search results to return"`
 	Threshold *float64 `help:"Maximum distance threshold for search results (20 is a good value)"`
 	Rerank    bool     `help:"Rerank search results based on the query (alpha)"`
+	LinkBoost *float64 `help:"Reduce distance of results linked from the top hits by this fraction (eg: 0.2)"`
 }
 
-type Reindex struct{}
+// Validate checks the flags of the search command after they are parsed
+func (s *Search) Validate() error {
+	if s.LinkBoost != nil && (*s.LinkBoost <= 0 || *s.LinkBoost >= 1) {
+		return fmt.Errorf("--link-boost must be between 0 and 1, got %v", *s.LinkBoost)
+	}
+
+	return nil
+}
+
+type Reindex struct {
+	NoBackup bool   `help:"Do not keep the previous database as a backup"`
+	Progress string `enum:"auto,bar,lines,none" default:"auto" help:"Show progress as a bar, as periodic lines or not at all (auto picks bar on a terminal)"`
+}
 
 type Show struct {
 	ID *int `arg:"" optional:"" help:"Optional document ID to show details for a specific document"`
@@ -52,8 +101,66 @@ type Remove struct {
 	ID int `arg:"" help:"Document ID to remove"`
 }
 
+type Recrawl struct{}
+
+type Feed struct {
+	Add  FeedAdd  `cmd:"" help:"Subscribe to an RSS/Atom feed"`
+	Sync FeedSync `cmd:"" help:"Add new entries from all subscribed feeds"`
+	List FeedList `cmd:"" help:"List subscribed feeds"`
+}
+
+type FeedAdd struct {
+	URL      string `arg:"" help:"URL of the feed"`
+	Articles bool   `help:"Index the article linked from each entry instead of the entry content"`
+}
+
+type FeedSync struct{}
+
+type FeedList struct{}
+
+type Migrate struct {
+	DryRun   bool `help:"Only list the migrations that would be applied"`
+	NoBackup bool `help:"Do not back up the database before migrating"`
+}
+
+type Export struct {
+	Output string `short:"o" help:"File to write the export to (default: stdout)"`
+}
+
+type Import struct {
+	File      string `arg:"" help:"Export file to import, - for stdin"`
+	AllowExec bool   `help:"Import documents created with --exec, their commands are run on reindex"`
+}
+
+type Relocate struct {
+	From string `required:"" help:"Directory the indexed files were moved from"`
+	To   string `required:"" help:"Directory the indexed files were moved to"`
+}
+
+type Links struct {
+	ID int `arg:"" help:"Document ID to list links for"`
+}
+
+type Backlinks struct {
+	ID int `arg:"" help:"Document ID to list backlinks for"`
+}
+
 func main() {
-	ctx := context.Background()
+	// Cancel the context on Ctrl-C so that indexing stops picking up new
+	// documents and saves the ones in progress. The signal handler is
+	// removed after the first one so that a second Ctrl-C exits
+	// immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		signal.Stop(signals)
+		log.Printf("Interrupted, finishing documents in progress (press Ctrl-C again to quit)")
+		cancel()
+	}()
 
 	// Load config
 	cfg, err := internal.LoadConfig()
@@ -73,7 +180,9 @@ func main() {
 
 	defer database.Close()
 
-	if new {
+	// An import initializes the database from the exported config so
+	// that the embedding model does not have to be available
+	if new && kctx.Command() != "import <file>" {
 		// Test embedding model as well as get the embedding size
 		sampleEmbedding, err := internal.CreateEmbedding(ctx, "refer")
 		if err != nil {
@@ -97,11 +206,27 @@ func main() {
 	}
 
 	if !new {
-		if kctx.Command() == "add <file-path>" || kctx.Command() == "search" {
+		// Bring databases created by older versions up to date. The
+		// migrate command does this itself so that it can be previewed.
+		if kctx.Command() != "migrate" {
+			pending, err := internal.PendingMigrations(database)
+			if err != nil {
+				log.Fatalf("Failed to check database schema: %v", err)
+			}
+
+			if len(pending) > 0 {
+				if err := migrateDatabase(database, cli.Database, true); err != nil {
+					log.Fatalf("Failed to migrate database: %v", err)
+				}
+			}
+		}
+
+		switch kctx.Command() {
+		case "add", "add <file-path>", "search", "recrawl", "feed add <url>", "feed sync":
 			// Check that the embedding model in the database matches the
-			// one in the config only if the command is add or
-			// search. This is necessary as the models must match for the
-			// results to be usable.
+			// one in the config only for commands that embed documents
+			// or queries. This is necessary as the models must match for
+			// the results to be usable.
 			config, err := internal.GetConfig(database)
 			if err != nil {
 				log.Fatalf("Failed to get config: %v", err)
@@ -120,65 +245,154 @@ func main() {
 		}
 	}
 
+	// The migrate command can run on databases that are not migrated
+	// yet and should only change what it lists
+	if kctx.Command() != "migrate" {
+		if err := internal.LoadIndexRoot(database, cli.Database); err != nil {
+			log.Fatalf("Failed to load index root: %v", err)
+		}
+	}
+
 	// Handle commands
 	switch kctx.Command() {
-	case "add <file-path>":
+	case "add", "add <file-path>":
+		if len(cli.Add.FilePath) == 0 && !cli.Add.Stdin && len(cli.Add.Exec) == 0 {
+			log.Fatalf("Nothing to add, pass a path, --stdin or --exec")
+		}
+
+		progress, err := internal.NewProgress(cli.Add.Progress)
+		if err != nil {
+			log.Fatalf("Invalid --progress: %v", err)
+		}
+		ctx := internal.WithProgress(ctx, progress)
+		log.SetOutput(progress)
+
+		if cli.Add.Stdin {
+			if cli.Add.Name == "" {
+				log.Fatalf("--name is required with --stdin")
+			}
+
+			if err := internal.AddStdinDocument(ctx, database, cli.Add.Name, os.Stdin); err != nil {
+				log.Printf("Error: %v", err)
+			}
+		}
+
+		walkOpts := internal.WalkOptions{
+			NoIgnore:          cli.Add.NoIgnore,
+			NoDefaultExcludes: cli.Add.NoDefaultExcludes,
+			Include:           cli.Add.Include,
+			Exclude:           cli.Add.Exclude,
+			FollowSymlinks:    cli.Add.FollowSymlinks,
+			SkipHidden:        cli.Add.SkipHidden,
+			OneFileSystem:     cli.Add.OneFileSystem,
+			MaxDepth:          cli.Add.MaxDepth,
+		}
+		if cli.Add.MaxSize != "" {
+			walkOpts.MaxSize, err = internal.ParseSize(cli.Add.MaxSize)
+			if err != nil {
+				log.Fatalf("Invalid --max-size: %v", err)
+			}
+		}
+
 		var allPaths []string
 		for _, f := range cli.Add.FilePath {
-			if internal.IsRemoteURL(f) {
-				allPaths = append(allPaths, f)
-			} else {
-				var matcher gitignore.Matcher
-				if !cli.Add.NoIgnore {
-					gitDir, err := internal.FindGitDir(f)
-					if err == nil {
-						patterns, err := internal.LoadGitignorePatterns(gitDir)
-						if err != nil {
-							log.Printf("Warning: could not load gitignore patterns: %v", err)
-						} else {
-							matcher = gitignore.NewMatcher(patterns)
-						}
-					}
+			if ctx.Err() != nil {
+				break
+			}
+
+			if cli.Add.Bookmarks {
+				file, err := os.Open(f)
+				if err != nil {
+					log.Printf("Failed to open bookmarks %q: %v", f, err)
+					continue
 				}
 
-				err = filepath.WalkDir(f, func(path string, dirEntry fs.DirEntry, err error) error {
-					if err != nil {
-						log.Printf("Failed to walk directory %q: %v", f, err)
-						return err
-					}
+				bookmarks, skipped, err := internal.ParseBookmarks(file)
+				file.Close()
+				if err != nil {
+					log.Printf("Failed to read bookmarks %q: %v", f, err)
+					continue
+				}
+				for _, err := range skipped {
+					log.Printf("Skipping entry in %s: %v", f, err)
+				}
 
-					// Skip if ignored by git
-					if matcher != nil {
-						relPath, err := filepath.Rel(f, path)
-						if err != nil {
-							return err
-						}
-
-						if matcher.Match(strings.Split(relPath, string(filepath.Separator)), dirEntry.IsDir()) {
-							if dirEntry.IsDir() {
-								return filepath.SkipDir
-							}
-							return nil
-						}
-					}
+				errors := internal.AddBookmarks(ctx, database, bookmarks, 5)
+				for _, err := range errors {
+					log.Printf("Error: %v", err)
+				}
 
-					if !dirEntry.IsDir() {
-						allPaths = append(allPaths, path)
+				fmt.Printf("Imported %d of %d bookmarks from %s\n", len(bookmarks)-len(errors), len(bookmarks), f)
+			} else if cli.Add.GitLog {
+				paths, err := internal.ExpandGitLog(database, f, cli.Add.GitDiff)
+				if err != nil {
+					log.Printf("Failed to read git log for %q: %v", f, err)
+					continue
+				}
+				allPaths = append(allPaths, paths...)
+			} else if internal.IsGitTreeURL(f) || cli.Add.Rev != "" {
+				url := f
+				if !internal.IsGitTreeURL(f) {
+					url, err = internal.GitTreeURL(f, cli.Add.Rev)
+					if err != nil {
+						log.Printf("Failed to read %q: %v", f, err)
+						continue
 					}
-					return nil
-				})
+				}
+
+				paths, err := internal.ExpandGitTree(url)
 				if err != nil {
-					log.Printf("Failed to walk directory %q: %v", f, err)
+					log.Printf("Failed to read git tree for %q: %v", f, err)
+					continue
+				}
+				allPaths = append(allPaths, paths...)
+			} else if internal.IsRemoteURL(f) && cli.Add.Crawl {
+				opts := internal.CrawlOptions{
+					Depth:       cli.Add.CrawlDepth,
+					Sitemap:     cli.Add.CrawlSitemap,
+					Include:     cli.Add.CrawlInclude,
+					Exclude:     cli.Add.CrawlExclude,
+					Concurrency: cli.Add.CrawlConcurrency,
+				}
+
+				for _, err := range internal.Crawl(ctx, database, f, opts) {
+					log.Printf("Error: %v", err)
+				}
+			} else if internal.IsRemoteURL(f) {
+				allPaths = append(allPaths, f)
+			} else {
+				paths, errors := internal.WalkPath(f, walkOpts)
+				for _, err := range errors {
+					log.Printf("Error: %v", err)
 				}
+				allPaths = append(allPaths, paths...)
 			}
 		}
 
-		// Process documents in parallel
-		if errors := internal.AddDocuments(ctx, database, allPaths, 5); len(errors) > 0 {
-			for _, err := range errors {
+		for _, command := range cli.Add.Exec {
+			if ctx.Err() != nil {
+				break
+			}
+
+			if err := internal.AddCommandDocument(ctx, database, command); err != nil {
 				log.Printf("Error: %v", err)
 			}
 		}
+
+		// Process documents in parallel
+		errors := internal.AddDocuments(ctx, database, allPaths, 5)
+		progress.Stop()
+		log.SetOutput(os.Stderr)
+
+		for _, err := range errors {
+			log.Printf("Error: %v", err)
+		}
+
+		progress.Summary(os.Stdout)
+
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted, run the command again to add the remaining documents")
+		}
 	case "search":
 		input, err := io.ReadAll(os.Stdin)
 		if err != nil {
@@ -231,6 +445,12 @@ func main() {
 
 		docs = uniqueDocs
 
+		if cli.Search.LinkBoost != nil {
+			if err := internal.BoostLinkedDocuments(database, docs, 3, *cli.Search.LinkBoost); err != nil {
+				log.Fatalf("Failed to boost linked documents: %v", err)
+			}
+		}
+
 		if cli.Search.Rerank {
 			docs, err = internal.RerankDocuments(cli.Search.Query[0], docs, cli.Search.Limit)
 			if err != nil {
@@ -257,105 +477,124 @@ func main() {
 			log.Fatalf("Failed to create embedding: %v", err)
 		}
 
-		embeddingSize := len(sampleEmbedding)
-
-		tempFile := os.TempDir() + "referdb"
-		tempDB, _, err := internal.CreateDB(tempFile)
+		progress, err := internal.NewProgress(cli.Reindex.Progress)
 		if err != nil {
-			log.Fatalf("Failed to create database: %v", err)
+			log.Fatalf("Invalid --progress: %v", err)
 		}
+		log.SetOutput(progress)
 
-		err = internal.InitDatabase(tempDB, len(sampleEmbedding))
-		if err != nil {
-			log.Fatalf("Failed to initialize database: %v", err)
+		result, err := internal.Reindex(internal.WithProgress(ctx, progress), database, cli.Database, internal.ReindexOptions{
+			EmbeddingSize: len(sampleEmbedding),
+			MaxWorkers:    5,
+			NoBackup:      cli.Reindex.NoBackup,
+		})
+		progress.Stop()
+		log.SetOutput(os.Stderr)
+		if result != nil {
+			for _, err := range result.Errors {
+				log.Printf("Error during reindex: %v", err)
+			}
 		}
-
-		err = internal.SaveConfig(
-			tempDB,
-			map[string]string{
-				"embedding_model": internal.Model,
-				"embedding_size":  fmt.Sprintf("%d", embeddingSize),
-			})
 		if err != nil {
-			log.Fatalf("Failed to save config: %v", err)
+			progress.Summary(os.Stdout)
+			log.Fatalf("Failed to reindex: %v", err)
 		}
 
-		originalConfig, err := internal.GetConfig(database)
-		if err != nil {
-			log.Fatalf("Failed to get config: %v", err)
+		fmt.Println("Successfully reindexed all documents")
+		fmt.Printf("Original documents: %d\n", result.Original)
+		if result.Resumed > 0 {
+			fmt.Printf("Resumed documents: %d\n", result.Resumed)
+		}
+		fmt.Printf("Changed documents: %d\n", result.Changed)
+		if result.Missing > 0 {
+			fmt.Printf("Missing documents: %d\n", result.Missing)
+		}
+		if result.Backup != "" {
+			fmt.Printf("Previous database kept at %s\n", result.Backup)
 		}
 
-		originalCount := 0
-		changedCount := 0
-
-		if originalConfig["embedding_model"] != internal.Model ||
-			originalConfig["embedding_size"] != fmt.Sprintf("%d", embeddingSize) {
-			// Re-embed everything
-			docs, err := internal.GetAllFilePaths(database)
+		fmt.Println()
+		progress.Summary(os.Stdout)
+	case "export":
+		out := os.Stdout
+		if cli.Export.Output != "" {
+			out, err = os.Create(cli.Export.Output)
 			if err != nil {
-				log.Fatalf("Failed to get existing documents: %v", err)
+				log.Fatalf("Failed to create %s: %v", cli.Export.Output, err)
 			}
+		}
 
-			if errors := internal.AddDocuments(ctx, tempDB, docs, 5); len(errors) > 0 {
-				for _, err := range errors {
-					log.Printf("Error during reindex: %v", err)
-				}
-			}
+		count, err := internal.Export(database, out)
+		if err != nil {
+			log.Fatalf("Failed to export: %v", err)
+		}
 
-			originalCount = len(docs)
-			changedCount = originalCount
-		} else {
-			// Re-embed only changed items
-			docs, err := internal.GetAllDocuments(database)
+		if out != os.Stdout {
+			if err := out.Close(); err != nil {
+				log.Fatalf("Failed to write %s: %v", cli.Export.Output, err)
+			}
+			fmt.Printf("Exported %d documents to %s\n", count, cli.Export.Output)
+		}
+	case "import <file>":
+		in := os.Stdin
+		if cli.Import.File != "-" {
+			in, err = os.Open(cli.Import.File)
 			if err != nil {
-				log.Fatalf("Failed to get existing documents: %v", err)
+				log.Fatalf("Failed to open %s: %v", cli.Import.File, err)
 			}
+			defer in.Close()
+		}
 
-			originalCount = len(docs)
-
-			for _, doc := range docs {
-				newDoc, err := internal.FetchDocument(doc.Path)
-				if err != nil {
-					log.Printf("Ignoring missing document: %s", doc.Path)
-					continue
+		count, skipped, err := internal.Import(database, bufio.NewReader(in), internal.ImportOptions{
+			AllowExec: cli.Import.AllowExec,
+		})
+		if err != nil {
+			// A new database is only usable once the export has been
+			// imported completely
+			if new {
+				if err := internal.DiscardDatabase(database, cli.Database); err != nil {
+					log.Printf("Failed to remove %s: %v", cli.Database, err)
 				}
+			}
+			log.Fatalf("Failed to import after %d documents: %v", count, err)
+		}
 
-				if newDoc.Content != doc.Content {
-					emb, err := internal.CreateAndSerializeEmbedding(ctx, newDoc.Content)
-					if err != nil {
-						log.Fatalf("Failed to create embedding for %s: %v", doc.Path, err)
-					}
+		fmt.Printf("Imported %d documents\n", count)
+		if skipped > 0 {
+			fmt.Printf("Skipped %d command documents, use --allow-exec to import them\n", skipped)
+		}
+	case "relocate":
+		result, err := internal.Relocate(database, cli.Relocate.From, cli.Relocate.To)
+		if err != nil {
+			log.Fatalf("Failed to relocate: %v", err)
+		}
 
-					err = internal.UpdateDocument(tempDB, newDoc, emb)
-					if err != nil {
-						log.Fatalf("Failed to update document %s: %v", doc.Path, err)
-					}
+		if result.Root != "" {
+			fmt.Printf("Moved index root to %s\n", result.Root)
+		}
+		fmt.Printf("Updated %d document paths\n", result.Documents)
+	case "migrate":
+		pending, err := internal.PendingMigrations(database)
+		if err != nil {
+			log.Fatalf("Failed to check database schema: %v", err)
+		}
 
-					changedCount++
-				} else {
-					emb, err := internal.GetDocumentEmbedding(database, doc.ID)
-					if err != nil {
-						log.Fatalf("Failed to get document embedding: %v", err)
-					}
+		if len(pending) == 0 {
+			fmt.Printf("Database is up to date (schema version %d)\n", internal.CurrentSchemaVersion)
+			return
+		}
 
-					err = internal.UpdateDocument(tempDB, newDoc, emb)
-					if err != nil {
-						log.Fatalf("Failed to update document %s: %v", doc.Path, err)
-					}
-				}
+		if cli.Migrate.DryRun {
+			fmt.Println("Pending migrations:")
+			for _, description := range pending {
+				fmt.Printf("- %s\n", description)
 			}
+			return
 		}
 
-		tempDB.Close()
-
-		// Move the temporary database to the original location
-		if err := os.Rename(tempFile, cli.Database); err != nil {
-			log.Fatalf("Failed to update database: %v", err)
+		if err := migrateDatabase(database, cli.Database, !cli.Migrate.NoBackup); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
 		}
-
-		fmt.Println("Successfully reindexed all documents")
-		fmt.Printf("Original documents: %d\n", originalCount)
-		fmt.Printf("Changed documents: %d\n", changedCount)
 	case "show":
 		// List all documents
 		docs, err := internal.GetAllDocuments(database)
@@ -378,7 +617,14 @@ func main() {
 		if doc == nil {
 			log.Fatalf("No document found with ID %d", *cli.Show.ID)
 		}
-		fmt.Printf("%s\n%s\n", doc.Path, doc.Content)
+		fmt.Printf("%s\n", internal.DisplayPath(doc.Path))
+		if doc.Title != doc.Path {
+			fmt.Printf("Title: %s\n", doc.Title)
+		}
+		for _, key := range slices.Sorted(maps.Keys(doc.Metadata)) {
+			fmt.Printf("%s: %s\n", key, doc.Metadata[key])
+		}
+		fmt.Printf("%s\n", doc.Content)
 	case "stats":
 		stats, err := internal.GetDatabaseStats(database)
 		if err != nil {
@@ -386,16 +632,85 @@ func main() {
 		}
 
 		fmt.Printf("Documents: %d\n", stats["documents"])
+		fmt.Printf("Chunks: %d\n", stats["chunks"])
 		fmt.Printf("Total Content Size: %s\n", formatBytes(stats["total_content_bytes"]))
 	case "remove <id>":
 		if err := internal.RemoveDocument(database, cli.Remove.ID); err != nil {
 			log.Fatalf("Failed to remove document: %v", err)
 		}
 		fmt.Printf("Document %d removed successfully\n", cli.Remove.ID)
+	case "recrawl":
+		for _, err := range internal.RecrawlAll(ctx, database) {
+			log.Printf("Error: %v", err)
+		}
+
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted, run the command again to crawl the remaining pages")
+		}
+	case "feed add <url>":
+		sub, err := internal.AddFeed(ctx, database, cli.Feed.Add.URL, cli.Feed.Add.Articles)
+		if err != nil {
+			log.Fatalf("Failed to add feed: %v", err)
+		}
+		fmt.Printf("Subscribed to %s (%s)\n", sub.Title, sub.URL)
+		fmt.Println("Run `refer feed sync` to add its entries")
+	case "feed sync":
+		for _, err := range internal.SyncFeeds(ctx, database) {
+			log.Printf("Error: %v", err)
+		}
+
+		if ctx.Err() != nil {
+			fmt.Println("Interrupted, run the command again to add the remaining entries")
+		}
+	case "feed list":
+		feeds, err := internal.GetFeeds(database)
+		if err != nil {
+			log.Fatalf("Failed to get feeds: %v", err)
+		}
+		for _, feed := range feeds {
+			synced := "never synced"
+			if !feed.LastSynced.IsZero() {
+				synced = "synced " + feed.LastSynced.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%s (%s, %s)\n", feed.URL, feed.Title, synced)
+		}
+	case "links <id>":
+		docs, err := internal.GetDocumentLinks(database, cli.Links.ID)
+		if err != nil {
+			log.Fatalf("Failed to get links: %v", err)
+		}
+		PrintLinkResults(docs)
+	case "backlinks <id>":
+		docs, err := internal.GetDocumentBacklinks(database, cli.Backlinks.ID)
+		if err != nil {
+			log.Fatalf("Failed to get backlinks: %v", err)
+		}
+		PrintLinkResults(docs)
 	default:
 		panic("Unexpected command: " + kctx.Command())
 	}
 }
+
+// migrateDatabase applies pending schema migrations, backing up the
+// database first
+func migrateDatabase(database *sql.DB, path string, backup bool) error {
+	if backup {
+		backupPath, err := internal.BackupDatabase(database, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Backed up database to %s\n", backupPath)
+	}
+
+	from, to, err := internal.Migrate(database)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Migrated database from schema version %d to %d\n", from, to)
+	return nil
+}
+
 func formatBytes(bytes int) string {
 	const unit = 1024
 	if bytes < unit {
@@ -411,17 +726,28 @@ func formatBytes(bytes int) string {
 
 func PrintNameResults(docs []internal.Document) {
 	for _, doc := range docs {
-		fmt.Printf("%d: %s (%.4f)\n", doc.ID, doc.Path, doc.Distance)
+		fmt.Printf("%d: %s (%.4f)\n", doc.ID, internal.DisplayPath(doc.Path), doc.Distance)
+	}
+}
+
+func PrintLinkResults(docs []internal.Document) {
+	for _, doc := range docs {
+		if doc.ID == 0 {
+			fmt.Printf("[-] %s (not indexed)\n", internal.DisplayPath(doc.Path))
+			continue
+		}
+		fmt.Printf("[%d] %s\n", doc.ID, internal.DisplayPath(doc.Path))
 	}
 }
 
 func PrintLLMResults(docs []internal.Document) {
 	// Print results in LLM format
 	for _, doc := range docs {
+		path := internal.DisplayPath(doc.Path)
 		if doc.Title != doc.Path {
-			fmt.Printf("File: %s\nTitle: %s\n\n```\n%s\n```\n---\n", doc.Path, doc.Title, doc.Content)
+			fmt.Printf("File: %s\nTitle: %s\n\n```\n%s\n```\n---\n", path, doc.Title, doc.Content)
 		} else {
-			fmt.Printf("File: %s\n\n```\n%s\n```\n---\n", doc.Path, doc.Content)
+			fmt.Printf("File: %s\n\n```\n%s\n```\n---\n", path, doc.Content)
 		}
 	}
 }